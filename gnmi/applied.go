@@ -0,0 +1,187 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/openconfig/ygot/ygot"
+	"github.com/openconfig/ygot/ytypes"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// WithAppliedState mirrors the committed config leaves in the state
+// containers, as devices report their applied config. At startup, then after
+// every commit, and delay later if delay is positive, each config leaf changed
+// in a config container is copied to the leaf of the same name in the sibling
+// state container of the state tree, or deleted from it. The delayed changes
+// are applied in the order of their commits.
+func WithAppliedState(delay time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.appliedState = true
+		s.appliedStateDelay = delay
+	}
+}
+
+// appliedStatePath returns the path of the state leaf applying the config
+// leaf at path, or nil if there is none.
+func (s *Server) appliedStatePath(path *pb.Path) *pb.Path {
+	elems := path.GetElem()
+	n := len(elems)
+	if n < 2 || elems[n-2].GetName() != "config" {
+		return nil
+	}
+	parent := s.model.schemaEntry(&pb.Path{Elem: elems[:n-2]})
+	if parent == nil {
+		return nil
+	}
+	state, ok := schemaChildren(parent)["state"]
+	if !ok {
+		return nil
+	}
+	if _, ok := schemaChildren(state)[elems[n-1].GetName()]; !ok {
+		return nil
+	}
+	stateElems := append([]*pb.PathElem{}, elems...)
+	stateElems[n-2] = &pb.PathElem{Name: "state"}
+	return &pb.Path{Elem: stateElems}
+}
+
+// appliedStateChanges returns the changes of the state leaves applying the
+// config changes in diff, or nil if there is none.
+func (s *Server) appliedStateChanges(diff *pb.Notification) *pb.Notification {
+	changes := &pb.Notification{}
+	for _, u := range diff.GetUpdate() {
+		if p := s.appliedStatePath(u.GetPath()); p != nil {
+			changes.Update = append(changes.Update, &pb.Update{Path: p, Val: u.GetVal()})
+		}
+	}
+	for _, path := range diff.GetDelete() {
+		if p := s.appliedStatePath(path); p != nil {
+			changes.Delete = append(changes.Delete, p)
+		}
+	}
+	if len(changes.Update) == 0 && len(changes.Delete) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// appliedChanges are the changes of the state leaves of a commit, waiting for
+// the applied state delay.
+type appliedChanges struct {
+	seq     uint64
+	changes *pb.Notification
+}
+
+// reflectAppliedState applies the config changes in diff to the state
+// leaves, now or after the applied state delay. The caller must hold s.mu.
+func (s *Server) reflectAppliedState(diff *pb.Notification) {
+	changes := s.appliedStateChanges(diff)
+	if changes == nil {
+		return
+	}
+	if s.appliedStateDelay <= 0 {
+		s.applyStateChanges(changes)
+		return
+	}
+	// The timers may fire out of order, so each timer applies the changes
+	// pending up to its own, which are all due.
+	s.appliedSeq++
+	seq := s.appliedSeq
+	s.appliedPending = append(s.appliedPending, appliedChanges{seq: seq, changes: changes})
+	time.AfterFunc(s.appliedStateDelay, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for len(s.appliedPending) > 0 && s.appliedPending[0].seq <= seq {
+			s.applyStateChanges(s.appliedPending[0].changes)
+			s.appliedPending = s.appliedPending[1:]
+		}
+	})
+}
+
+// applyStateChanges applies the changes of the state leaves to a copy of the
+// state tree, which replaces the state tree, and publishes them. The caller
+// must hold s.mu.
+func (s *Server) applyStateChanges(changes *pb.Notification) {
+	state, err := s.copyState()
+	if err != nil {
+		log.Errorf("error in applying state: %v", err)
+		return
+	}
+	n := &pb.Notification{}
+	for _, u := range changes.GetUpdate() {
+		if err := ytypes.SetNode(s.model.schemaTreeRoot, state, u.GetPath(), u.GetVal(), &ytypes.InitMissingElements{}); err != nil {
+			log.Errorf("error in applying %v to state: %v", u.GetPath(), err)
+			continue
+		}
+		n.Update = append(n.Update, u)
+	}
+	for _, p := range changes.GetDelete() {
+		// The state leaf is gone if the state tree was updated meanwhile.
+		if err := ytypes.DeleteNode(s.model.schemaTreeRoot, state, p); err != nil {
+			continue
+		}
+		s.dropKeyOnlyEntries(state, p)
+		n.Delete = append(n.Delete, p)
+	}
+	if len(n.Update) == 0 && len(n.Delete) == 0 {
+		return
+	}
+	if err := s.commitState(state, []*pb.Notification{n}); err != nil {
+		log.Errorf("error in applying state: %v", err)
+	}
+}
+
+// dropKeyOnlyEntries deletes from the state tree the list entries above path
+// left with their keys only, such as the entries of the config lists deleted,
+// which would otherwise still be served.
+func (s *Server) dropKeyOnlyEntries(state ygot.GoStruct, path *pb.Path) {
+	elems := path.GetElem()
+	for i := len(elems) - 1; i >= 0; i-- {
+		keys := elems[i].GetKey()
+		if len(keys) == 0 {
+			continue
+		}
+		entryPath := &pb.Path{Elem: elems[:i+1]}
+		nodes, err := ytypes.GetNode(s.model.schemaTreeRoot, state, entryPath)
+		if err != nil || len(nodes) == 0 {
+			return
+		}
+		entry, ok := nodes[0].Data.(ygot.GoStruct)
+		if !ok {
+			return
+		}
+		n, err := ygot.TogNMINotifications(entry, 0, ygot.GNMINotificationsConfig{UsePathElem: true})
+		if err != nil || len(n) == 0 {
+			return
+		}
+		for _, u := range n[0].GetUpdate() {
+			leaf := u.GetPath().GetElem()
+			if len(leaf) != 1 {
+				return
+			}
+			if _, ok := keys[leaf[0].GetName()]; !ok {
+				return
+			}
+		}
+		if err := ytypes.DeleteNode(s.model.schemaTreeRoot, state, entryPath); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,137 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/openconfig/ygot/ygot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+	extpb "github.com/openconfig/gnmi/proto/gnmi_ext"
+)
+
+// DiagnosticsOrigin is the origin of the read-only paths exposing the state
+// of the server. The primary of every role of the master arbitration is found
+// at /master-arbitration/role[id=<role>], or all of them at
+// /master-arbitration. The counters of the STREAM subscriptions are found at
+// /subscriptions.
+const DiagnosticsOrigin = "diagnostics"
+
+// checkArbitration checks that the SetRequest comes from the primary of its
+// role, which has the highest election ID of the role, and records its
+// election ID. A SetRequest without MasterArbitration extension belongs to
+// the default role with election ID 0. The election IDs are replaced rather
+// than modified, and published to the readers of the diagnostics. The caller
+// must hold s.mu.
+func (s *Server) checkArbitration(req *pb.SetRequest) error {
+	var ma *extpb.MasterArbitration
+	for _, e := range req.GetExtension() {
+		if e.GetMasterArbitration() == nil {
+			continue
+		}
+		if ma != nil {
+			return status.Error(codes.InvalidArgument, "more than one master arbitration extension")
+		}
+		ma = e.GetMasterArbitration()
+	}
+
+	role := ma.GetRole().GetId()
+	id := ma.GetElectionId()
+	if primary, ok := s.electionIDs[role]; ok && electionIDLess(id, primary) {
+		return status.Errorf(codes.PermissionDenied, "election ID %v is lower than the election ID %v of the primary of role %q", id, primary, role)
+	}
+	if primary, ok := s.electionIDs[role]; ma == nil || ok && proto.Equal(primary, id) {
+		return nil
+	}
+	ids := make(map[string]*extpb.Uint128, len(s.electionIDs)+1)
+	for r, primary := range s.electionIDs {
+		ids[r] = primary
+	}
+	ids[role] = &extpb.Uint128{High: id.GetHigh(), Low: id.GetLow()}
+	s.electionIDs = ids
+	s.publishElectionIDs()
+	return nil
+}
+
+// electionIDLess returns true if the election ID a is lower than b.
+func electionIDLess(a, b *extpb.Uint128) bool {
+	if a.GetHigh() != b.GetHigh() {
+		return a.GetHigh() < b.GetHigh()
+	}
+	return a.GetLow() < b.GetLow()
+}
+
+// diagnosticsHandler serves the DiagnosticsOrigin of the server.
+type diagnosticsHandler struct {
+	s *Server
+}
+
+// Get returns the primary of a role, or of every role, or the subscription
+// counters, as IETF JSON.
+func (h diagnosticsHandler) Get(_ ygot.ValidatedGoStruct, path *pb.Path) (*pb.TypedValue, error) {
+	elems := path.GetElem()
+	if len(elems) == 1 && elems[0].GetName() == "subscriptions" {
+		return h.s.subscriptionDiagnostics()
+	}
+	if len(elems) == 0 || elems[0].GetName() != "master-arbitration" || len(elems) > 2 ||
+		len(elems) == 2 && (elems[1].GetName() != "role" || len(elems[1].GetKey()) != 1) {
+		return nil, status.Errorf(codes.NotFound, "path %v not found", path)
+	}
+
+	electionIDs := h.s.snapshot().electionIDs
+	roles := make([]string, 0, len(electionIDs))
+	for role := range electionIDs {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	var entries []map[string]interface{}
+	for _, role := range roles {
+		id := electionIDs[role]
+		entries = append(entries, map[string]interface{}{
+			"id": role,
+			"election-id": map[string]interface{}{
+				"high": strconv.FormatUint(id.GetHigh(), 10),
+				"low":  strconv.FormatUint(id.GetLow(), 10),
+			},
+		})
+	}
+
+	var tree interface{} = map[string]interface{}{"role": entries}
+	if len(elems) == 2 {
+		role, ok := elems[1].GetKey()["id"]
+		i := sort.SearchStrings(roles, role)
+		if !ok || i == len(roles) || roles[i] != role {
+			return nil, status.Errorf(codes.NotFound, "path %v not found", path)
+		}
+		tree = entries[i]
+	}
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error in marshaling diagnostics to JSON: %v", err)
+	}
+	return &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: b}}, nil
+}
+
+// Set rejects the operation, since the diagnostics are read-only.
+func (h diagnosticsHandler) Set(_ ygot.ValidatedGoStruct, _ pb.UpdateResult_Operation, path *pb.Path, _ *pb.TypedValue) error {
+	return status.Errorf(codes.PermissionDenied, "diagnostics path %v is read-only", path)
+}
@@ -0,0 +1,145 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/openconfig/ygot/ygot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+	extpb "github.com/openconfig/gnmi/proto/gnmi_ext"
+)
+
+// defaultRollbackDuration is the time to wait for the confirmation of a commit
+// without rollback duration before rolling it back.
+const defaultRollbackDuration = 10 * time.Minute
+
+// pendingCommit is a commit of the commit confirmed extension waiting for its
+// confirmation.
+type pendingCommit struct {
+	id        string
+	oldConfig ygot.ValidatedGoStruct // config to roll back to.
+	timer     *time.Timer
+}
+
+// commitExtension returns the commit confirmed extension of the SetRequest,
+// or nil if there is none. Return error if the extension is invalid.
+func commitExtension(req *pb.SetRequest) (*extpb.Commit, error) {
+	var commit *extpb.Commit
+	for _, e := range req.GetExtension() {
+		if e.GetCommit() == nil {
+			continue
+		}
+		if commit != nil {
+			return nil, errors.New("more than one commit extension")
+		}
+		commit = e.GetCommit()
+	}
+	switch {
+	case commit == nil:
+		return nil, nil
+	case commit.GetId() == "":
+		return nil, errors.New("commit ID is required")
+	case commit.GetAction() == nil:
+		return nil, errors.New("commit action is required")
+	case commit.GetCommit() == nil && (len(req.GetDelete()) > 0 || len(req.GetReplace()) > 0 ||
+		len(req.GetUpdate()) > 0 || len(req.GetUnionReplace()) > 0):
+		return nil, errors.New("only the commit action can be requested with operations")
+	}
+	return commit, nil
+}
+
+// startCommit waits for the confirmation of the commit, whose operations are
+// applied to oldConfig, and rolls the commit back if the rollback duration
+// expires first. It is started before the commit is applied, so that
+// oldConfig stays persisted until the confirmation. The caller must hold s.mu.
+func (s *Server) startCommit(commit *extpb.Commit, oldConfig ygot.ValidatedGoStruct) {
+	s.commit = &pendingCommit{
+		id:        commit.GetId(),
+		oldConfig: oldConfig,
+	}
+	s.startRollbackTimer(commit.GetCommit().GetRollbackDuration().AsDuration())
+}
+
+// startRollbackTimer rolls back the pending commit after d, or the default
+// rollback duration if d is unset, replacing the previous timer of the commit.
+// The caller must hold s.mu.
+func (s *Server) startRollbackTimer(d time.Duration) {
+	if d <= 0 {
+		d = defaultRollbackDuration
+	}
+	c := s.commit
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.commit != c || c.timer != timer {
+			// The commit was confirmed, cancelled or got a new timer meanwhile.
+			return
+		}
+		if err := s.rollbackCommit(); err != nil {
+			log.Errorf("error in rolling back commit %q: %v", c.id, err)
+		}
+	})
+	c.timer = timer
+}
+
+// doCommitAction confirms, cancels or sets the rollback duration of the
+// pending commit. The caller must hold s.mu.
+func (s *Server) doCommitAction(commit *extpb.Commit) error {
+	if s.commit == nil {
+		return status.Error(codes.FailedPrecondition, "no commit is waiting for confirmation")
+	}
+	if commit.GetId() != s.commit.id {
+		return status.Errorf(codes.InvalidArgument, "commit ID %q does not match the pending commit %q", commit.GetId(), s.commit.id)
+	}
+
+	switch {
+	case commit.GetConfirm() != nil:
+		s.commit.timer.Stop()
+		s.commit = nil
+		if err := s.persistConfig(s.config); err != nil {
+			// The commit is confirmed, so only log the failure.
+			log.Errorf("error in persisting the running config: %v", err)
+		}
+	case commit.GetCancel() != nil:
+		return s.rollbackCommit()
+	case commit.GetSetRollbackDuration() != nil:
+		s.startRollbackTimer(commit.GetSetRollbackDuration().GetRollbackDuration().AsDuration())
+	}
+	return nil
+}
+
+// rollbackCommit applies the config preceding the pending commit back to the
+// device. The caller must hold s.mu.
+func (s *Server) rollbackCommit() error {
+	c := s.commit
+	c.timer.Stop()
+	s.commit = nil
+	diff, err := diffConfigs(s.config, c.oldConfig)
+	if err != nil {
+		return status.Errorf(codes.Internal, "error in computing the config changes: %v", err)
+	}
+	return s.commitConfig(c.oldConfig, "", diff)
+}
@@ -0,0 +1,116 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/openconfig/goyang/pkg/yang"
+	"github.com/openconfig/ygot/ygot"
+	"github.com/openconfig/ygot/ytypes"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+	extpb "github.com/openconfig/gnmi/proto/gnmi_ext"
+)
+
+// depthExtension returns the level of the Depth extension in the extensions,
+// or 0, meaning no depth limit, if there is none. Return error if there is
+// more than one Depth extension.
+func depthExtension(exts []*extpb.Extension) (uint32, error) {
+	var depth *extpb.Depth
+	for _, e := range exts {
+		if e.GetDepth() == nil {
+			continue
+		}
+		if depth != nil {
+			return 0, errors.New("more than one depth extension")
+		}
+		depth = e.GetDepth()
+	}
+	return depth.GetLevel(), nil
+}
+
+// truncateUpdates returns the updates whose leaf is at most depth levels
+// below the node whose path has base elements, and the sorted paths of the
+// subtrees depth levels below the node whose leaves are dropped.
+func truncateUpdates(updates []*pb.Update, base int, depth uint32) ([]*pb.Update, []*pb.Path, error) {
+	var kept []*pb.Update
+	truncated := map[string]*pb.Path{}
+	for _, u := range updates {
+		elems := u.GetPath().GetElem()
+		if len(elems) <= base+int(depth) {
+			kept = append(kept, u)
+			continue
+		}
+		p := &pb.Path{Elem: elems[:base+int(depth)]}
+		key, err := ygot.PathToString(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		truncated[key] = p
+	}
+
+	keys := make([]string, 0, len(truncated))
+	for k := range truncated {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	paths := make([]*pb.Path, len(keys))
+	for i, k := range keys {
+		paths[i] = truncated[k]
+	}
+	return kept, paths, nil
+}
+
+// truncateNode returns a copy of node, found at path with the schema
+// nodeSchema, without the subtrees more than depth levels below it, and the
+// paths of the truncated subtrees relative to node.
+func truncateNode(node ygot.GoStruct, path *pb.Path, nodeSchema *yang.Entry, depth uint32) (ygot.GoStruct, []*pb.Path, error) {
+	notifs, err := ygot.TogNMINotifications(node, 0, ygot.GNMINotificationsConfig{UsePathElem: true})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in flattening node %v: %v", path, err)
+	}
+	_, truncated, err := truncateUpdates(notifs[0].GetUpdate(), 0, depth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(truncated) == 0 {
+		return node, nil, nil
+	}
+	pruned, err := ygot.DeepCopy(node)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error in copying node %v: %v", path, err)
+	}
+	for _, p := range truncated {
+		if err := ytypes.DeleteNode(nodeSchema, pruned, p); err != nil {
+			return nil, nil, fmt.Errorf("error in truncating subtree %v of node %v: %v", p, path, err)
+		}
+	}
+	return pruned, truncated, nil
+}
+
+// truncatedUpdate returns the update reporting the truncated subtree at path,
+// whose value is an empty JSON object in the JSON encoding of the response,
+// or in IETF JSON for the PROTO encoding. Clients get the content of the
+// subtree with a request at its path.
+func truncatedUpdate(path *pb.Path, encoding pb.Encoding) *pb.Update {
+	if encoding == pb.Encoding_JSON {
+		return &pb.Update{Path: path, Val: &pb.TypedValue{Value: &pb.TypedValue_JsonVal{JsonVal: []byte("{}")}}}
+	}
+	return &pb.Update{Path: path, Val: &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte("{}")}}}
+}
@@ -0,0 +1,84 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/openconfig/ygot/ygot"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// diffConfigs returns the leaves updated and the paths of the leaves deleted
+// from oldConfig to newConfig, as ygot.Diff does. The leaves are matched by
+// path in a map, which keeps the diff of large configs linear in their size.
+func diffConfigs(oldConfig, newConfig ygot.GoStruct) (*pb.Notification, error) {
+	oldLeaves, err := configLeaves(oldConfig)
+	if err != nil {
+		return nil, err
+	}
+	newLeaves, err := configLeaves(newConfig)
+	if err != nil {
+		return nil, err
+	}
+	diff := &pb.Notification{}
+	for _, p := range sortedKeys(newLeaves) {
+		u := newLeaves[p]
+		if old, ok := oldLeaves[p]; !ok || !proto.Equal(old.GetVal(), u.GetVal()) {
+			diff.Update = append(diff.Update, u)
+		}
+	}
+	for _, p := range sortedKeys(oldLeaves) {
+		if _, ok := newLeaves[p]; !ok {
+			diff.Delete = append(diff.Delete, oldLeaves[p].GetPath())
+		}
+	}
+	return diff, nil
+}
+
+// configLeaves returns the updates of the leaves of config, with their full
+// paths, by path string.
+func configLeaves(config ygot.GoStruct) (map[string]*pb.Update, error) {
+	notifications, err := ygot.TogNMINotifications(config, 0, ygot.GNMINotificationsConfig{UsePathElem: true})
+	if err != nil {
+		return nil, fmt.Errorf("error in rendering the leaves of the config: %v", err)
+	}
+	leaves := make(map[string]*pb.Update)
+	for _, n := range notifications {
+		for _, u := range n.GetUpdate() {
+			path := gnmiFullPath(n.GetPrefix(), u.GetPath())
+			p, err := ygot.PathToString(path)
+			if err != nil {
+				return nil, err
+			}
+			leaves[p] = &pb.Update{Path: path, Val: u.GetVal()}
+		}
+	}
+	return leaves, nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]*pb.Update) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/openconfig/gnmi/value"
+	"github.com/openconfig/goyang/pkg/yang"
+	"github.com/openconfig/ygot/ygot"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// leafValue returns the scalar TypedValue of the leaf or leaf-list data found
+// in the config struct. Enumerations are encoded as strings and leaf-lists as
+// ScalarArray.
+func (s *Server) leafValue(data interface{}) (*pb.TypedValue, error) {
+	switch kind := reflect.ValueOf(data).Kind(); kind {
+	case reflect.Ptr, reflect.Interface:
+		return value.FromScalar(reflect.ValueOf(data).Elem().Interface())
+	case reflect.Int64:
+		enumMap, ok := s.model.enumData[reflect.TypeOf(data).Name()]
+		if !ok {
+			return nil, errors.New("not a GoStruct enumeration type")
+		}
+		return &pb.TypedValue{
+			Value: &pb.TypedValue_StringVal{
+				StringVal: enumMap[reflect.ValueOf(data).Int()].Name,
+			},
+		}, nil
+	case reflect.Slice:
+		return ygot.EncodeTypedValue(data, pb.Encoding_PROTO)
+	default:
+		return nil, fmt.Errorf("unexpected kind of leaf node type: %v %v", data, kind)
+	}
+}
+
+// protoUpdates rewrites the values of the updates whose path, relative to
+// prefix, is a decimal64 leaf or leaf-list of the schema from the float
+// values of the config struct to Decimal64 values.
+func (s *Server) protoUpdates(prefix *pb.Path, updates []*pb.Update) {
+	for _, u := range updates {
+		e := s.model.schemaEntry(gnmiFullPath(prefix, u.GetPath()))
+		if e == nil || e.Type == nil || e.Type.Kind != yang.Ydecimal64 {
+			continue
+		}
+		u.Val = decimalValue(u.GetVal(), e.Type.FractionDigits)
+	}
+}
+
+// decimalValue converts the float values in val to Decimal64 values with the
+// given number of fraction digits.
+func decimalValue(val *pb.TypedValue, fractionDigits int) *pb.TypedValue {
+	var f float64
+	switch v := val.GetValue().(type) {
+	case *pb.TypedValue_FloatVal:
+		f = float64(v.FloatVal)
+	case *pb.TypedValue_DoubleVal:
+		f = v.DoubleVal
+	case *pb.TypedValue_LeaflistVal:
+		elems := make([]*pb.TypedValue, len(v.LeaflistVal.GetElement()))
+		for i, elem := range v.LeaflistVal.GetElement() {
+			elems[i] = decimalValue(elem, fractionDigits)
+		}
+		return &pb.TypedValue{Value: &pb.TypedValue_LeaflistVal{LeaflistVal: &pb.ScalarArray{Element: elems}}}
+	default:
+		return val
+	}
+	return &pb.TypedValue{
+		Value: &pb.TypedValue_DecimalVal{
+			DecimalVal: &pb.Decimal64{
+				Digits:    int64(math.Round(f * math.Pow10(fractionDigits))),
+				Precision: uint32(fractionDigits),
+			},
+		},
+	}
+}
@@ -0,0 +1,181 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openconfig/goyang/pkg/yang"
+	"github.com/openconfig/ygot/util"
+	"github.com/openconfig/ygot/ygot"
+	"github.com/openconfig/ygot/ytypes"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// leafFilter returns true if the leaf at path, whose schema node is e, is to
+// be kept in a response.
+type leafFilter func(path *pb.Path, e *yang.Entry) bool
+
+// WithOperationalFilter replaces the default definition of the OPERATIONAL
+// data of Get requests, which is the state data that is not the applied value
+// of a config leaf, such as the counters.
+func WithOperationalFilter(f func(*yang.Entry) bool) ServerOpt {
+	return func(s *Server) {
+		s.isOperational = f
+	}
+}
+
+// isOperational returns true for the state leaves that do not mirror a leaf of
+// the sibling config container.
+func isOperational(e *yang.Entry) bool {
+	if util.IsConfig(e) {
+		return false
+	}
+	if p := e.Parent; p != nil && p.Name == "state" && p.Parent != nil {
+		if config, ok := p.Parent.Dir["config"]; ok {
+			_, applied := config.Dir[e.Name]
+			return !applied
+		}
+	}
+	return true
+}
+
+// dataTypeFilter returns the leafFilter of the data type of a Get request, or
+// nil for ALL.
+func (s *Server) dataTypeFilter(t pb.GetRequest_DataType) (leafFilter, error) {
+	switch t {
+	case pb.GetRequest_ALL:
+		return nil, nil
+	case pb.GetRequest_CONFIG:
+		return func(_ *pb.Path, e *yang.Entry) bool { return util.IsConfig(e) }, nil
+	case pb.GetRequest_STATE:
+		return func(_ *pb.Path, e *yang.Entry) bool { return !util.IsConfig(e) }, nil
+	case pb.GetRequest_OPERATIONAL:
+		return func(_ *pb.Path, e *yang.Entry) bool { return !util.IsConfig(e) && s.isOperational(e) }, nil
+	}
+	return nil, fmt.Errorf("unsupported request type: %s", pb.GetRequest_DataType_name[int32(t)])
+}
+
+// allFilters returns a leafFilter keeping the leaves kept by all the non-nil
+// filters, or nil if there is none.
+func allFilters(filters ...leafFilter) leafFilter {
+	var fs []leafFilter
+	for _, f := range filters {
+		if f != nil {
+			fs = append(fs, f)
+		}
+	}
+	if len(fs) == 0 {
+		return nil
+	}
+	return func(path *pb.Path, e *yang.Entry) bool {
+		for _, f := range fs {
+			if !f(path, e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// pathFilter turns f into a filter of data paths, dropping the paths that are
+// not in the schema.
+func (s *Server) pathFilter(f leafFilter) func(*pb.Path) bool {
+	return func(path *pb.Path) bool {
+		e := s.model.schemaEntry(path)
+		return e != nil && f(path, e)
+	}
+}
+
+// isListKey returns true if e is the key leaf of a list entry.
+func isListKey(e *yang.Entry) bool {
+	if e.Parent == nil || !e.Parent.IsList() {
+		return false
+	}
+	for _, k := range strings.Fields(e.Parent.Key) {
+		if k == e.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneNode returns a copy of node, found at path with the schema nodeSchema,
+// without the leaves dropped by keep. The keys of the list entries having
+// other leaves left are kept, and the list entries with no leaf left are
+// removed.
+func (s *Server) pruneNode(node ygot.GoStruct, path *pb.Path, nodeSchema *yang.Entry, keep leafFilter) (ygot.GoStruct, error) {
+	notifs, err := ygot.TogNMINotifications(node, 0, ygot.GNMINotificationsConfig{UsePathElem: true})
+	if err != nil {
+		return nil, fmt.Errorf("error in flattening node %v: %v", path, err)
+	}
+	pruned, err := ygot.DeepCopy(node)
+	if err != nil {
+		return nil, fmt.Errorf("error in copying node %v: %v", path, err)
+	}
+
+	// entries holds the relative paths of the list entries, and keptEntries
+	// the ones with a leaf left.
+	entries := map[string]*pb.Path{}
+	keptEntries := map[string]bool{}
+	for _, u := range notifs[0].GetUpdate() {
+		relPath := u.GetPath()
+		fullPath := &pb.Path{Elem: append(append([]*pb.PathElem{}, path.GetElem()...), relPath.GetElem()...)}
+		e := s.model.schemaEntry(fullPath)
+		if e == nil {
+			return nil, fmt.Errorf("path %v is not in the schema", fullPath)
+		}
+		if isListKey(e) {
+			continue
+		}
+		kept := keep(fullPath, e)
+		if !kept {
+			if err := ytypes.DeleteNode(nodeSchema, pruned, relPath); err != nil {
+				return nil, fmt.Errorf("error in pruning leaf %v: %v", fullPath, err)
+			}
+		}
+		for i, elem := range relPath.GetElem() {
+			if elem.GetKey() == nil {
+				continue
+			}
+			entryPath := &pb.Path{Elem: relPath.GetElem()[:i+1]}
+			key, err := ygot.PathToString(entryPath)
+			if err != nil {
+				return nil, err
+			}
+			entries[key] = entryPath
+			keptEntries[key] = keptEntries[key] || kept
+		}
+	}
+
+	// Delete the outer entries first, the entries below are then gone.
+	var emptyEntries []string
+	for key := range entries {
+		if !keptEntries[key] {
+			emptyEntries = append(emptyEntries, key)
+		}
+	}
+	sort.Strings(emptyEntries)
+	for _, key := range emptyEntries {
+		if err := ytypes.DeleteNode(nodeSchema, pruned, entries[key]); err != nil {
+			return nil, fmt.Errorf("error in pruning list entry %v: %v", key, err)
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,138 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/openconfig/ygot/ygot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+	extpb "github.com/openconfig/gnmi/proto/gnmi_ext"
+)
+
+// revision is a config committed at a point in time.
+type revision struct {
+	id        uint64
+	timestamp int64 // time of the commit in nanoseconds since the epoch.
+	user      string
+	config    ygot.ValidatedGoStruct
+	diff      *pb.Notification // changes from the previous revision.
+}
+
+// WithHistorySize sets the number of config revisions kept to serve the
+// History extension and the revisions API. The oldest revision is dropped
+// when a new one is committed to a full history. Every revision holds a copy
+// of the config, so the history is disabled by default, with a size of 0.
+func WithHistorySize(n int) ServerOpt {
+	return func(s *Server) {
+		s.historySize = n
+	}
+}
+
+// recordRevision appends a copy of config, committed now by user with the
+// changes in diff, to the history as a new numbered revision. The caller must
+// hold s.mu.
+func (s *Server) recordRevision(config ygot.ValidatedGoStruct, user string, diff *pb.Notification) {
+	if s.historySize <= 0 {
+		return
+	}
+	c, err := ygot.DeepCopy(config)
+	if err != nil {
+		log.Errorf("error in copying config struct for the history: %v", err)
+		return
+	}
+	s.lastRevisionID++
+	s.history = append(s.history, &revision{
+		id:        s.lastRevisionID,
+		timestamp: time.Now().UnixNano(),
+		user:      user,
+		config:    c.(ygot.ValidatedGoStruct),
+		diff:      diff,
+	})
+	if len(s.history) > s.historySize {
+		s.history = append(s.history[:0:0], s.history[len(s.history)-s.historySize:]...)
+	}
+}
+
+// historyExtension returns the History extension in the extensions, or nil if
+// there is none. Return error if the extension is invalid.
+func historyExtension(exts []*extpb.Extension) (*extpb.History, error) {
+	var hist *extpb.History
+	for _, e := range exts {
+		if e.GetHistory() == nil {
+			continue
+		}
+		if hist != nil {
+			return nil, errors.New("more than one history extension")
+		}
+		hist = e.GetHistory()
+	}
+	if r := hist.GetRange(); r != nil && r.GetStart() > r.GetEnd() {
+		return nil, errors.New("history range starts after its end")
+	}
+	return hist, nil
+}
+
+// doHistoryReplay processes a Subscription with a History extension, whose
+// snapshot time or time range selects revs. It pushes a Notification message
+// with the values of the subscribed paths in the first revision, unless only
+// updates are requested, and one with the changes made by each following
+// revision, timestamped with the commit of the revisions. Then it pushes the
+// sync token and closes the queue.
+func (s *Server) doHistoryReplay(c *streamClient, revs []*revision) {
+	cs := s.newChangeSubscriber(c, c.sr.GetSubscribe().GetSubscription())
+	if !c.sr.GetSubscribe().GetUpdatesOnly() {
+		for i, fullPath := range cs.paths {
+			updates, err := s.updatesFromNode(revs[0].config, fullPath)
+			if err != nil {
+				log.Errorf("error in getting updates of path %v: %v", fullPath, err)
+				continue
+			}
+			if cs.filters[i] != nil {
+				if updates = filterUpdates(updates, cs.filters[i]); updates == nil {
+					continue
+				}
+			}
+			if c.depth > 0 {
+				if updates, err = c.truncate(updates, fullPath); err != nil {
+					c.errC <- status.Errorf(codes.Internal, "error in truncating path %v: %v", fullPath, err)
+					return
+				}
+			}
+			c.msgQ.Insert(&pb.Notification{
+				Timestamp: revs[0].timestamp,
+				Update:    updates,
+			})
+		}
+	}
+	for i := 1; i < len(revs); i++ {
+		diff, err := diffConfigs(revs[i-1].config, revs[i].config)
+		if err != nil {
+			c.errC <- status.Errorf(codes.Internal, "error in computing the config changes: %v", err)
+			return
+		}
+		if n := cs.changes(diff, revs[i].timestamp); n != nil {
+			c.msgQ.Insert(n)
+		}
+	}
+	c.msgQ.Insert(subscribeSyncToken{})
+	c.msgQ.Close()
+}
@@ -0,0 +1,205 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/openconfig/goyang/pkg/yang"
+	"github.com/openconfig/ygot/util"
+	"github.com/openconfig/ygot/ygot"
+	"github.com/openconfig/ygot/ytypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// leafref is a leafref leaf of the model. Its value must be the value of one
+// of the leaves it references, found by going up the data tree from the leaf
+// by up elements, or from the root if up is negative, then down the names.
+type leafref struct {
+	up    int
+	names []string
+}
+
+// leafrefIndex indexes the leafrefs of a model by the schema paths of the
+// leafref leaves and of the leaves they reference. The leafref leaf-lists are
+// not indexed, as ygot does not validate them.
+type leafrefIndex struct {
+	refs    map[string]*leafref
+	targets map[string]bool
+	// predicates is set if a leafref path has predicates, which are not
+	// resolved by checkLeafref.
+	predicates bool
+}
+
+// leafrefs returns the leafref index of the model.
+func (m *Model) leafrefs() *leafrefIndex {
+	m.leafrefsOnce.Do(func() {
+		m.leafrefIdx = &leafrefIndex{
+			refs:    make(map[string]*leafref),
+			targets: make(map[string]bool),
+		}
+		m.leafrefIdx.index(m.schemaTreeRoot, nil)
+	})
+	return m.leafrefIdx
+}
+
+// index records the leafref leaves of the schema node e at the schema path
+// names, and below.
+func (idx *leafrefIndex) index(e *yang.Entry, names []string) {
+	if e.IsLeafList() {
+		return
+	}
+	if e.IsLeaf() {
+		if !util.IsLeafRef(e) {
+			return
+		}
+		path := util.StripModulePrefixesStr(e.Type.Path)
+		if strings.Contains(path, "[") {
+			idx.predicates = true
+			return
+		}
+		ref := &leafref{up: -1}
+		target := []string{}
+		if !strings.HasPrefix(path, "/") {
+			ref.up = 0
+			target = append(target, names...)
+		}
+		for _, name := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+			if name == ".." {
+				ref.up++
+				target = target[:len(target)-1]
+				continue
+			}
+			ref.names = append(ref.names, name)
+			target = append(target, name)
+		}
+		idx.refs[schemaPathString(names)] = ref
+		idx.targets[schemaPathString(target)] = true
+		return
+	}
+	for name, ch := range schemaChildren(e) {
+		idx.index(ch, append(append([]string{}, names...), name))
+	}
+}
+
+// schemaPathString returns the schema path of the element names.
+func schemaPathString(names []string) string {
+	return "/" + strings.Join(names, "/")
+}
+
+// dataSchemaPath returns the schema path of the data path, without its keys.
+func dataSchemaPath(path *pb.Path) string {
+	names := make([]string, len(path.GetElem()))
+	for i, elem := range path.GetElem() {
+		names[i] = elem.GetName()
+	}
+	return schemaPathString(names)
+}
+
+// validateLeafrefs checks the leafrefs of newConfig affected by the changes
+// from the valid oldConfig. The leafrefs updated by the diff are resolved one
+// by one, and all the leafrefs of newConfig are checked only when a leaf they
+// may reference is deleted or modified, or when the model has leafref paths
+// with predicates.
+func (s *Server) validateLeafrefs(oldConfig, newConfig ygot.ValidatedGoStruct, diff *pb.Notification) error {
+	idx := s.model.leafrefs()
+	full := idx.predicates
+	for _, p := range diff.GetDelete() {
+		if idx.targets[dataSchemaPath(p)] {
+			full = true
+			break
+		}
+	}
+	var updated []*pb.Update
+	for _, u := range diff.GetUpdate() {
+		if full {
+			break
+		}
+		schemaPath := dataSchemaPath(u.GetPath())
+		if idx.targets[schemaPath] {
+			nodes, err := ytypes.GetNode(s.model.schemaTreeRoot, oldConfig, u.GetPath())
+			if err == nil && len(nodes) > 0 {
+				// A referenced value is modified.
+				full = true
+				break
+			}
+		}
+		if idx.refs[schemaPath] != nil {
+			updated = append(updated, u)
+		}
+	}
+	if full {
+		if errs := ytypes.ValidateLeafRefData(s.model.schemaTreeRoot, newConfig, nil); errs != nil {
+			return errs
+		}
+		return nil
+	}
+	for _, u := range updated {
+		if err := s.checkLeafref(newConfig, idx.refs[dataSchemaPath(u.GetPath())], u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLeafref checks that the value of the leafref leaf updated by u is the
+// value of one of the leaves of config referenced by ref.
+func (s *Server) checkLeafref(config ygot.ValidatedGoStruct, ref *leafref, u *pb.Update) error {
+	var elems []*pb.PathElem
+	if ref.up >= 0 {
+		elems = append(elems, u.GetPath().GetElem()[:len(u.GetPath().GetElem())-ref.up]...)
+	}
+	for _, name := range ref.names {
+		elems = append(elems, &pb.PathElem{Name: name})
+	}
+	targetPath := &pb.Path{Elem: elems}
+	nodes, err := ytypes.GetNode(s.model.schemaTreeRoot, config, targetPath, &ytypes.GetPartialKeyMatch{})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	for _, n := range nodes {
+		if util.IsValueNil(n.Data) {
+			continue
+		}
+		vals := []interface{}{n.Data}
+		if v := reflect.ValueOf(n.Data); v.Kind() == reflect.Slice {
+			vals = vals[:0]
+			for i := 0; i < v.Len(); i++ {
+				vals = append(vals, v.Index(i).Interface())
+			}
+		}
+		for _, val := range vals {
+			tv, err := ygot.EncodeTypedValue(val, pb.Encoding_JSON)
+			if err != nil {
+				return err
+			}
+			if proto.Equal(tv, u.GetVal()) {
+				return nil
+			}
+		}
+	}
+	str, err := ygot.PathToString(u.GetPath())
+	if err != nil {
+		str = u.GetPath().String()
+	}
+	return fmt.Errorf("leafref %s value %v is not equal to any leaf at %s", str, u.GetVal(), dataSchemaPath(targetPath))
+}
@@ -20,8 +20,11 @@ import (
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 
 	"github.com/openconfig/goyang/pkg/yang"
+	"github.com/openconfig/ygot/util"
 	"github.com/openconfig/ygot/ygot"
 	"github.com/openconfig/ygot/ytypes"
 
@@ -41,6 +44,12 @@ type Model struct {
 	schemaTreeRoot  *yang.Entry
 	jsonUnmarshaler JSONUnmarshaler
 	enumData        GoStructEnumData
+
+	modulesOnce sync.Once
+	modules     map[*yang.Entry]string // modules maps schema nodes to their YANG module.
+
+	leafrefsOnce sync.Once
+	leafrefIdx   *leafrefIndex
 }
 
 // NewModel returns an instance of Model struct.
@@ -58,6 +67,78 @@ func (m *Model) newRootValue() interface{} {
 	return reflect.New(m.structRootType.Elem()).Interface()
 }
 
+// schemaEntry returns the schema node of the data path, whose list keys are
+// ignored, or nil if the path is not in the schema.
+func (m *Model) schemaEntry(path *pb.Path) *yang.Entry {
+	e := m.schemaTreeRoot
+	for _, elem := range path.GetElem() {
+		next, ok := e.Dir[elem.GetName()]
+		if !ok {
+			// The child may be under a choice or a case.
+			next, ok = schemaChildren(e)[elem.GetName()]
+		}
+		if !ok {
+			return nil
+		}
+		e = next
+	}
+	return e
+}
+
+// isDataPath returns true if the path is in the schema, with keys only on the
+// elems of lists.
+func (m *Model) isDataPath(path *pb.Path) bool {
+	for i, elem := range path.GetElem() {
+		if len(elem.GetKey()) == 0 {
+			continue
+		}
+		if e := m.schemaEntry(&pb.Path{Elem: path.GetElem()[:i+1]}); e == nil || !e.IsList() {
+			return false
+		}
+	}
+	return m.schemaEntry(path) != nil
+}
+
+// moduleOf returns the name of the YANG module defining the schema node e,
+// which may augment a node of another module.
+func (m *Model) moduleOf(e *yang.Entry) string {
+	m.modulesOnce.Do(func() {
+		m.modules = make(map[*yang.Entry]string)
+		m.indexModules(m.structRootType.Elem(), m.schemaTreeRoot)
+	})
+	return m.modules[e]
+}
+
+// indexModules records the modules of the children of the schema node e, as
+// found in the "module" tags of the GoStruct type t.
+func (m *Model) indexModules(t reflect.Type, e *yang.Entry) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		module, ok := f.Tag.Lookup("module")
+		if !ok {
+			continue
+		}
+		child, err := util.ChildSchema(e, f)
+		if err != nil || child == nil {
+			continue
+		}
+		// The tag holds a module per element of the path tag.
+		modules := strings.Split(module, "/")
+		m.modules[child] = modules[len(modules)-1]
+
+		ft := f.Type
+		switch ft.Kind() {
+		case reflect.Ptr:
+			ft = ft.Elem()
+		case reflect.Map:
+			ft = ft.Elem().Elem()
+		}
+		if ft.Kind() == reflect.Struct {
+			m.indexModules(ft, child)
+		}
+	}
+}
+
 // NewConfigStruct creates a ValidatedGoStruct of this model from jsonConfig. If jsonConfig is nil, creates an empty GoStruct.
 func (m *Model) NewConfigStruct(jsonConfig []byte) (ygot.ValidatedGoStruct, error) {
 	rootStruct, ok := m.newRootValue().(ygot.ValidatedGoStruct)
@@ -0,0 +1,163 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"github.com/openconfig/ygot/ygot"
+	"github.com/openconfig/ygot/ytypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// OpenConfigOrigin is the origin of the paths served by the YANG model of the
+// server, which also serves the paths without origin.
+const OpenConfigOrigin = "openconfig"
+
+// OriginHandler serves the config of a gNMI origin other than the YANG model,
+// such as the ASCII config of a "cli" origin. The config of every origin is a
+// view of the same config struct of the model, which is the effective config
+// of the device, so a handler translates its config from and to the struct.
+type OriginHandler interface {
+	// Get returns the value of the path of the origin in config.
+	Get(config ygot.ValidatedGoStruct, path *pb.Path) (*pb.TypedValue, error)
+	// Set applies an operation of a SetRequest on the path of the origin to
+	// config. val is nil for a DELETE. For a UNION_REPLACE, config holds the
+	// union of the union_replace payloads of the OpenConfig origin, merged
+	// into the config not replaced by them, and the handler replaces the
+	// config of its origin with val.
+	Set(config ygot.ValidatedGoStruct, op pb.UpdateResult_Operation, path *pb.Path, val *pb.TypedValue) error
+}
+
+// WithOriginHandler registers the handler serving the paths of the origin.
+func WithOriginHandler(origin string, h OriginHandler) ServerOpt {
+	return func(s *Server) {
+		s.origins[origin] = h
+	}
+}
+
+// originHandler returns the handler of the origin of the path, which may be
+// given in the prefix, or nil if the YANG model serves the origin. Return
+// error if the origin is unsupported.
+func (s *Server) originHandler(prefix, path *pb.Path) (OriginHandler, error) {
+	origin := prefix.GetOrigin()
+	if path.GetOrigin() != "" {
+		origin = path.GetOrigin()
+	}
+	if origin == "" || origin == OpenConfigOrigin {
+		return nil, nil
+	}
+	h, ok := s.origins[origin]
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "unsupported origin: %s", origin)
+	}
+	return h, nil
+}
+
+// originPath returns the full path of the path relative to prefix, without
+// origin.
+func originPath(prefix, path *pb.Path) *pb.Path {
+	return &pb.Path{Elem: gnmiFullPath(prefix, path).GetElem()}
+}
+
+// originError returns the error of an origin handler as a gRPC status error.
+func originError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// doOriginSet applies an operation of a SetRequest on the origin of h to the
+// config.
+func (s *Server) doOriginSet(config ygot.ValidatedGoStruct, h OriginHandler, op pb.UpdateResult_Operation, prefix, path *pb.Path, val *pb.TypedValue) (*pb.UpdateResult, error) {
+	if err := h.Set(config, op, originPath(prefix, path), val); err != nil {
+		return nil, originError(err)
+	}
+	return &pb.UpdateResult{
+		Path: path,
+		Op:   op,
+	}, nil
+}
+
+// doUnionReplace applies the union_replace operations of a SetRequest to the
+// config. The union of the payloads of the OpenConfig origin
+// replaces the data at their paths, then the origin handlers replace the
+// config of their origin.
+func (s *Server) doUnionReplace(config ygot.ValidatedGoStruct, prefix *pb.Path, updates []*pb.Update) ([]*pb.UpdateResult, error) {
+	union, err := s.model.NewConfigStruct(nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	results := make([]*pb.UpdateResult, len(updates))
+	handlers := make([]OriginHandler, len(updates))
+	for i, upd := range updates {
+		results[i] = &pb.UpdateResult{Path: upd.GetPath(), Op: pb.UpdateResult_UNION_REPLACE}
+		h, err := s.originHandler(prefix, upd.GetPath())
+		if err != nil {
+			return nil, err
+		}
+		if handlers[i] = h; h != nil {
+			continue
+		}
+
+		fullPath := originPath(prefix, upd.GetPath())
+		payload, grpcStatusError := s.newConfigAt(fullPath, upd.GetVal())
+		if grpcStatusError != nil {
+			return nil, grpcStatusError
+		}
+		if err := ygot.MergeStructInto(union, payload); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "conflicting union_replace payloads: %v", err)
+		}
+		if len(fullPath.GetElem()) == 0 {
+			clearStruct(config)
+			continue
+		}
+		if err := ytypes.DeleteNode(s.model.schemaTreeRoot, config, fullPath); err != nil {
+			return nil, status.Errorf(codes.NotFound, "path %v is not found in the config structure: %v", fullPath, err)
+		}
+	}
+	if err := ygot.MergeStructInto(config, union, &ygot.MergeOverwriteExistingFields{}); err != nil {
+		return nil, status.Errorf(codes.Internal, "error in merging union_replace payloads: %v", err)
+	}
+
+	for i, h := range handlers {
+		if h == nil {
+			continue
+		}
+		if err := h.Set(config, pb.UpdateResult_UNION_REPLACE, originPath(prefix, updates[i].GetPath()), updates[i].GetVal()); err != nil {
+			return nil, originError(err)
+		}
+	}
+	return results, nil
+}
+
+// newConfigAt returns a config struct holding only val at path.
+func (s *Server) newConfigAt(path *pb.Path, val *pb.TypedValue) (ygot.ValidatedGoStruct, error) {
+	config := s.model.newRootValue().(ygot.ValidatedGoStruct)
+	if len(path.GetElem()) == 0 {
+		if err := s.model.jsonUnmarshaler(val.GetJsonIetfVal(), config); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "unmarshaling json data to config struct fails: %v", err)
+		}
+		return config, nil
+	}
+	if err := ytypes.SetNode(s.model.schemaTreeRoot, config, path, val, &ytypes.InitMissingElements{}); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "error in setting %v in config struct: %v", path, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,110 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/golang/glog"
+	"github.com/openconfig/ygot/ygot"
+)
+
+// backupSuffix is appended to the path of the persisted running config to
+// name the backup of its previous version.
+const backupSuffix = ".bak"
+
+// WithPersistence persists the running config to the file at path as IETF
+// JSON after every commit, keeping the previous version in path + ".bak". A
+// commit of the commit confirmed extension is persisted once confirmed.
+// NewServer then loads the running config from the file, or from its backup
+// if the file does not hold a valid config, and falls back to the startup
+// config if neither does.
+func WithPersistence(path string) ServerOpt {
+	return func(s *Server) {
+		s.persistPath = path
+	}
+}
+
+// bootConfig returns the config the server boots with and its IETF JSON data,
+// which is nil for an empty config.
+func (s *Server) bootConfig(startup []byte) (ygot.ValidatedGoStruct, []byte, error) {
+	if s.persistPath != "" {
+		for _, path := range []string{s.persistPath, s.persistPath + backupSuffix} {
+			data, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				log.Errorf("error in reading persisted config %s: %v", path, err)
+				continue
+			}
+			config, err := s.model.NewConfigStruct(data)
+			if err != nil {
+				log.Errorf("invalid persisted config %s: %v", path, err)
+				continue
+			}
+			log.Infof("loaded persisted config %s", path)
+			return config, data, nil
+		}
+	}
+	config, err := s.model.NewConfigStruct(startup)
+	return config, startup, err
+}
+
+// persistConfig atomically writes config to the persistence file, if any,
+// after moving the previous version to the backup file. The caller must hold
+// s.mu.
+func (s *Server) persistConfig(config ygot.ValidatedGoStruct) error {
+	if s.persistPath == "" {
+		return nil
+	}
+	jsonTree, err := ygot.ConstructIETFJSON(config, &ygot.RFC7951JSONConfig{AppendModuleName: true})
+	if err != nil {
+		return fmt.Errorf("error in constructing IETF JSON tree from config struct: %v", err)
+	}
+	data, err := json.MarshalIndent(jsonTree, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error in marshaling IETF JSON tree to bytes: %v", err)
+	}
+
+	// Write a temporary file first, so that the persisted config is never
+	// partially written.
+	tmp, err := ioutil.TempFile(filepath.Dir(s.persistPath), filepath.Base(s.persistPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(s.persistPath, s.persistPath+backupSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.persistPath)
+}
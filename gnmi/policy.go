@@ -0,0 +1,364 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/openconfig/goyang/pkg/yang"
+	"github.com/openconfig/ygot/ygot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// PathAuthorizer authorizes the access of the users to the data paths. The
+// origin of the paths is set for the origins other than OpenConfigOrigin.
+type PathAuthorizer interface {
+	// CanRead returns true if user may read the data at path.
+	CanRead(user string, path *pb.Path) bool
+	// CanWrite returns true if user may modify the data at path.
+	CanWrite(user string, path *pb.Path) bool
+}
+
+// WithPathAuthorizer restricts the access of the users of the requests, found
+// with UserFromContext, to the data paths allowed by a. Get and Subscribe
+// responses only hold the data the user may read, and a Set changing data the
+// user may not write is rejected.
+func WithPathAuthorizer(a PathAuthorizer) ServerOpt {
+	return func(s *Server) {
+		s.authorizer = a
+	}
+}
+
+// readFilter returns the leafFilter keeping the leaves user may read, or nil
+// if the server has no PathAuthorizer.
+func (s *Server) readFilter(user string) leafFilter {
+	if s.authorizer == nil {
+		return nil
+	}
+	return func(path *pb.Path, _ *yang.Entry) bool {
+		return s.authorizer.CanRead(user, path)
+	}
+}
+
+// checkOriginAccess checks that user may read, or write if write is true, the
+// path of an origin served by an OriginHandler. The paths of the YANG model
+// are not checked.
+func (s *Server) checkOriginAccess(user string, prefix, path *pb.Path, write bool) error {
+	if s.authorizer == nil {
+		return nil
+	}
+	origin := prefix.GetOrigin()
+	if path.GetOrigin() != "" {
+		origin = path.GetOrigin()
+	}
+	if origin == "" || origin == OpenConfigOrigin {
+		return nil
+	}
+	fullPath := originPath(prefix, path)
+	fullPath.Origin = origin
+	if write && !s.authorizer.CanWrite(user, fullPath) {
+		return status.Errorf(codes.PermissionDenied, "user %q may not write path %v", user, fullPath)
+	}
+	if !write && !s.authorizer.CanRead(user, fullPath) {
+		return status.Errorf(codes.PermissionDenied, "user %q may not read path %v", user, fullPath)
+	}
+	return nil
+}
+
+// checkSetOriginAccess checks that user may write the paths of the origins
+// served by an OriginHandler in the SetRequest.
+func (s *Server) checkSetOriginAccess(user string, req *pb.SetRequest) error {
+	paths := append([]*pb.Path{}, req.GetDelete()...)
+	for _, updates := range [][]*pb.Update{req.GetReplace(), req.GetUpdate(), req.GetUnionReplace()} {
+		for _, u := range updates {
+			paths = append(paths, u.GetPath())
+		}
+	}
+	for _, path := range paths {
+		if err := s.checkOriginAccess(user, req.GetPrefix(), path, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWriteAccess checks that user may write every leaf updated and every
+// path deleted by the config changes of diff. The key leaf of a new list entry
+// is written along with the other leaves of the entry, so it may be written if
+// the user may write one of them. The error lists the paths the user may not
+// write.
+func (s *Server) checkWriteAccess(user string, diff *pb.Notification) error {
+	if s.authorizer == nil {
+		return nil
+	}
+	paths := append([]*pb.Path{}, diff.GetDelete()...)
+	for _, u := range diff.GetUpdate() {
+		if entry := listKeyLeafEntry(u.GetPath()); entry != nil && s.writesListEntry(user, entry, diff.GetUpdate()) {
+			continue
+		}
+		paths = append(paths, u.GetPath())
+	}
+	var denied []string
+	for _, p := range paths {
+		if s.authorizer.CanWrite(user, p) {
+			continue
+		}
+		str, err := ygot.PathToString(p)
+		if err != nil {
+			return status.Errorf(codes.Internal, "error in converting path %v to string: %v", p, err)
+		}
+		denied = append(denied, str)
+	}
+	if denied != nil {
+		sort.Strings(denied)
+		return status.Errorf(codes.PermissionDenied, "user %q may not write paths: %s", user, strings.Join(denied, ", "))
+	}
+	return nil
+}
+
+// listKeyLeafEntry returns the elems of the list entry of which path is a key
+// leaf, such as /interfaces/interface[name=eth0] for
+// /interfaces/interface[name=eth0]/name, or nil if path is not a key leaf.
+func listKeyLeafEntry(path *pb.Path) []*pb.PathElem {
+	elems := path.GetElem()
+	if len(elems) < 2 {
+		return nil
+	}
+	entry := elems[:len(elems)-1]
+	if _, ok := entry[len(entry)-1].GetKey()[elems[len(elems)-1].GetName()]; !ok {
+		return nil
+	}
+	return entry
+}
+
+// writesListEntry returns true if user may write one of the leaves below the
+// list entry, other than key leaves, updated by updates.
+func (s *Server) writesListEntry(user string, entry []*pb.PathElem, updates []*pb.Update) bool {
+	for _, u := range updates {
+		elems := u.GetPath().GetElem()
+		if len(elems) <= len(entry) || listKeyLeafEntry(u.GetPath()) != nil {
+			continue
+		}
+		below := true
+		for i, e := range entry {
+			if !proto.Equal(e, elems[i]) {
+				below = false
+				break
+			}
+		}
+		if below && s.authorizer.CanWrite(user, u.GetPath()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Access levels of the rules of a Policy.
+const (
+	accessDeny  = "deny"
+	accessRead  = "read"
+	accessWrite = "write"
+)
+
+// Policy is a PathAuthorizer granting access per path prefix, in the spirit of
+// gNSI pathz. It is defined by a JSON document such as:
+//
+//	{
+//	  "groups": {"ops": ["alice", "bob"]},
+//	  "rules": [
+//	    {"path": "/", "groups": ["ops"], "access": "read"},
+//	    {"path": "/interfaces/interface[name=*]/config", "users": ["alice"], "access": "write"},
+//	    {"path": "/system/aaa", "groups": ["ops"], "access": "deny"},
+//	    {"origin": "cli", "path": "/", "users": ["alice"], "access": "write"}
+//	  ]
+//	}
+//
+// A rule applies to the users it lists, or all users for "*", and to the
+// members of the groups it lists. It covers the data at its path and below,
+// where "*" matches any element name or key value, and the keys missing from
+// an element match any value. The access is "read", "write", which implies
+// read, or "deny".
+//
+// The rule deciding the access of a user to a path is the covering rule with
+// the longest path, preferring the rules listing the user to the ones of its
+// groups, then the most restrictive one. A user has no access to the paths
+// that no rule covers.
+type Policy struct {
+	groups map[string]map[string]bool // users of every group.
+	rules  []*policyRule
+}
+
+type policyRule struct {
+	origin string
+	path   *pb.Path
+	users  map[string]bool
+	groups []string
+	access string
+}
+
+// NewPolicy returns the Policy defined by the JSON document data.
+func NewPolicy(data []byte) (*Policy, error) {
+	var doc struct {
+		Groups map[string][]string `json:"groups"`
+		Rules  []struct {
+			Origin string   `json:"origin"`
+			Path   string   `json:"path"`
+			Users  []string `json:"users"`
+			Groups []string `json:"groups"`
+			Access string   `json:"access"`
+		} `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error in parsing policy: %v", err)
+	}
+
+	p := &Policy{groups: make(map[string]map[string]bool)}
+	for group, users := range doc.Groups {
+		p.groups[group] = make(map[string]bool)
+		for _, u := range users {
+			p.groups[group][u] = true
+		}
+	}
+	for i, r := range doc.Rules {
+		switch r.Access {
+		case accessDeny, accessRead, accessWrite:
+		default:
+			return nil, fmt.Errorf("rule %d: invalid access %q", i, r.Access)
+		}
+		for _, g := range r.Groups {
+			if _, ok := p.groups[g]; !ok {
+				return nil, fmt.Errorf("rule %d: unknown group %q", i, g)
+			}
+		}
+		path, err := ygot.StringToStructuredPath(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid path %q: %v", i, r.Path, err)
+		}
+		rule := &policyRule{
+			origin: r.Origin,
+			path:   path,
+			users:  make(map[string]bool),
+			groups: r.Groups,
+			access: r.Access,
+		}
+		if rule.origin == OpenConfigOrigin {
+			rule.origin = ""
+		}
+		for _, u := range r.Users {
+			rule.users[u] = true
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// CanRead implements PathAuthorizer.
+func (p *Policy) CanRead(user string, path *pb.Path) bool {
+	access := p.access(user, path)
+	return access == accessRead || access == accessWrite
+}
+
+// CanWrite implements PathAuthorizer.
+func (p *Policy) CanWrite(user string, path *pb.Path) bool {
+	return p.access(user, path) == accessWrite
+}
+
+// access returns the access of user to path granted by the deciding rule, or
+// accessDeny if no rule covers the path.
+func (p *Policy) access(user string, path *pb.Path) string {
+	origin := path.GetOrigin()
+	if origin == OpenConfigOrigin {
+		origin = ""
+	}
+	var best *policyRule
+	var bestDirect bool
+	for _, r := range p.rules {
+		if r.origin != origin || !r.covers(path) {
+			continue
+		}
+		direct := r.users[user] || r.users["*"]
+		if !direct && !p.inGroups(user, r.groups) {
+			continue
+		}
+		if best == nil || r.outranks(direct, best, bestDirect) {
+			best, bestDirect = r, direct
+		}
+	}
+	if best == nil {
+		return accessDeny
+	}
+	return best.access
+}
+
+// inGroups returns true if user is a member of one of the groups.
+func (p *Policy) inGroups(user string, groups []string) bool {
+	for _, g := range groups {
+		if p.groups[g][user] {
+			return true
+		}
+	}
+	return false
+}
+
+// outranks returns true if r, applying directly to the user or not, decides
+// the access over the rule other.
+func (r *policyRule) outranks(direct bool, other *policyRule, otherDirect bool) bool {
+	if l, ol := len(r.path.GetElem()), len(other.path.GetElem()); l != ol {
+		return l > ol
+	}
+	if direct != otherDirect {
+		return direct
+	}
+	return accessRank(r.access) < accessRank(other.access)
+}
+
+// accessRank orders the access levels from the most restrictive.
+func accessRank(access string) int {
+	switch access {
+	case accessDeny:
+		return 0
+	case accessRead:
+		return 1
+	}
+	return 2
+}
+
+// covers returns true if the path of r is a prefix of path.
+func (r *policyRule) covers(path *pb.Path) bool {
+	elems := path.GetElem()
+	if len(r.path.GetElem()) > len(elems) {
+		return false
+	}
+	for i, re := range r.path.GetElem() {
+		e := elems[i]
+		if re.GetName() != "*" && re.GetName() != e.GetName() {
+			return false
+		}
+		for k, v := range re.GetKey() {
+			if v != "*" && e.GetKey()[k] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
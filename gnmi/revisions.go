@@ -0,0 +1,141 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/openconfig/ygot/ygot"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// userKey is the context key of the user of a request.
+type userKey struct{}
+
+// NewUserContext returns a copy of ctx holding the user of the request, who
+// is recorded in the config revisions committed by the request.
+func NewUserContext(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the user of the request held by ctx, or an empty
+// string if there is none.
+func UserFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// Revision is a numbered config revision kept in the history of the server.
+type Revision struct {
+	ID        uint64
+	Timestamp time.Time
+	// User is the user committing the revision, empty for the startup config
+	// and the rollbacks of unconfirmed commits.
+	User string
+	// Diff holds the changes from the previous revision.
+	Diff *pb.Notification
+}
+
+// Revisions returns the revisions kept in the history, from the oldest. With a
+// PathAuthorizer, their changes only hold the paths the user of ctx may read.
+func (s *Server) Revisions(ctx context.Context) []*Revision {
+	user := UserFromContext(ctx)
+	history := s.snapshot().history
+	revs := make([]*Revision, len(history))
+	for i, rev := range history {
+		revs[i] = &Revision{
+			ID:        rev.id,
+			Timestamp: time.Unix(0, rev.timestamp),
+			User:      rev.user,
+			Diff:      s.readableDiff(user, rev.diff),
+		}
+	}
+	return revs
+}
+
+// RevisionDiff returns the changes from the revision from to the revision to.
+// With a PathAuthorizer, they only hold the paths the user of ctx may read.
+func (s *Server) RevisionDiff(ctx context.Context, from, to uint64) (*pb.Notification, error) {
+	snap := s.snapshot()
+	fromRev, err := snap.revisionByID(from)
+	if err != nil {
+		return nil, err
+	}
+	toRev, err := snap.revisionByID(to)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := diffConfigs(fromRev.config, toRev.config)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error in computing the config changes: %v", err)
+	}
+	return s.readableDiff(UserFromContext(ctx), diff), nil
+}
+
+// readableDiff returns the config changes of diff at the paths user may read,
+// or diff if the server has no PathAuthorizer.
+func (s *Server) readableDiff(user string, diff *pb.Notification) *pb.Notification {
+	if s.authorizer == nil || diff == nil {
+		return diff
+	}
+	readable := &pb.Notification{Timestamp: diff.GetTimestamp()}
+	for _, u := range diff.GetUpdate() {
+		if s.authorizer.CanRead(user, u.GetPath()) {
+			readable.Update = append(readable.Update, u)
+		}
+	}
+	for _, p := range diff.GetDelete() {
+		if s.authorizer.CanRead(user, p) {
+			readable.Delete = append(readable.Delete, p)
+		}
+	}
+	return readable
+}
+
+// Rollback replaces the config with the config of the revision through a Set
+// of the root, validated and committed as a new revision like any Set. The
+// rollback is an operator action outside of the master arbitration, so it is
+// not checked against the election IDs of the primaries. It fails with
+// FailedPrecondition while a commit confirmed Set waits for confirmation, as
+// the pending commit has to be confirmed or cancelled first.
+func (s *Server) Rollback(ctx context.Context, id uint64) (*pb.SetResponse, error) {
+	rev, err := s.snapshot().revisionByID(id)
+	if err != nil {
+		return nil, err
+	}
+	jsonTree, err := ygot.ConstructIETFJSON(rev.config, &ygot.RFC7951JSONConfig{AppendModuleName: true})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error in constructing IETF JSON tree from config struct: %v", err)
+	}
+	jsonDump, err := json.Marshal(jsonTree)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error in marshaling IETF JSON tree to bytes: %v", err)
+	}
+	return s.set(ctx, &pb.SetRequest{
+		Replace: []*pb.Update{{
+			Path: &pb.Path{},
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: jsonDump}},
+		}},
+	}, false)
+}
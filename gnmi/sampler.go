@@ -0,0 +1,234 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/openconfig/ygot/ygot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// WithSubscriberQueueLimit disconnects a STREAM client as a slow consumer,
+// with a ResourceExhausted error, once its queue holds n messages not yet
+// sent. The number of clients disconnected is found at /subscriptions of the
+// DiagnosticsOrigin. The queues are unbounded if n is 0, the default.
+func WithSubscriberQueueLimit(n int) ServerOpt {
+	return func(s *Server) {
+		s.queueLimit = n
+	}
+}
+
+// sampler runs the STREAM SAMPLE subscriptions of all the clients. The
+// subscriptions are grouped by path, sample interval and heartbeat interval:
+// each group walks the data tree once per tick, and fans the sampled values
+// out to the queues of its clients.
+type sampler struct {
+	s      *Server
+	mu     sync.Mutex // mu protects groups and their members.
+	groups map[sampleKey]*sampleGroup
+}
+
+// sampleKey identifies a group of SAMPLE subscriptions.
+type sampleKey struct {
+	path      string
+	interval  time.Duration
+	heartbeat time.Duration // heartbeat is only set with suppress_redundant.
+}
+
+// sampleGroup is a group of SAMPLE subscriptions sampled together.
+type sampleGroup struct {
+	path    *pb.Path
+	members map[*sampleMember]bool
+	stop    chan struct{}
+}
+
+// sampleMember is the SAMPLE subscription of a client in a group. Its sample
+// state is only accessed by the goroutine of the group once it joined.
+type sampleMember struct {
+	c   *streamClient
+	sub *pb.Subscription
+	st  *sampleState
+}
+
+func newSampler(s *Server) *sampler {
+	return &sampler{s: s, groups: make(map[sampleKey]*sampleGroup)}
+}
+
+// join adds the member to the group of its path and intervals, which is
+// started if it has no other member.
+func (sp *sampler) join(m *sampleMember, fullPath *pb.Path) {
+	key := sampleKey{interval: time.Duration(m.sub.GetSampleInterval())}
+	if key.interval == 0 {
+		key.interval = minStreamSampleInterval
+	}
+	if m.st.suppressRedundant {
+		key.heartbeat = time.Duration(m.sub.GetHeartbeatInterval())
+	}
+	var err error
+	if key.path, err = ygot.PathToString(fullPath); err != nil {
+		// The path is still sampled, without sharing.
+		log.Errorf("error in converting path %v to string: %v", fullPath, err)
+		key.path = fullPath.String()
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	g, ok := sp.groups[key]
+	if !ok {
+		g = &sampleGroup{
+			path:    fullPath,
+			members: make(map[*sampleMember]bool),
+			stop:    make(chan struct{}),
+		}
+		sp.groups[key] = g
+		go sp.run(key, g)
+	}
+	g.members[m] = true
+}
+
+// leave removes the member from its groups, and stops the groups left
+// without members.
+func (sp *sampler) leave(m *sampleMember) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for key, g := range sp.groups {
+		if !g.members[m] {
+			continue
+		}
+		delete(g.members, m)
+		if len(g.members) == 0 {
+			close(g.stop)
+			delete(sp.groups, key)
+		}
+	}
+}
+
+// groupCount returns the number of groups running.
+func (sp *sampler) groupCount() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return len(sp.groups)
+}
+
+// run samples the path of the group at every tick until the group is
+// stopped.
+func (sp *sampler) run(key sampleKey, g *sampleGroup) {
+	ticker := time.NewTicker(key.interval)
+	defer ticker.Stop()
+	var heartbeatC <-chan time.Time
+	if key.heartbeat > 0 {
+		heartbeatTicker := time.NewTicker(key.heartbeat)
+		defer heartbeatTicker.Stop()
+		heartbeatC = heartbeatTicker.C
+	}
+	for {
+		var heartbeat bool
+		select {
+		case <-ticker.C:
+		case <-heartbeatC:
+			heartbeat = true
+		case <-g.stop:
+			return
+		}
+		n, err := sp.s.sampleUpdates(g.path)
+		if err != nil {
+			log.Errorf("error in sampling path %v: %v", g.path, err)
+			continue
+		}
+		sp.mu.Lock()
+		members := make([]*sampleMember, 0, len(g.members))
+		for m := range g.members {
+			members = append(members, m)
+		}
+		sp.mu.Unlock()
+		for _, m := range members {
+			if mn := m.st.filter(m.sample(sp.s, n), heartbeat); mn != nil {
+				m.c.insert(mn)
+			}
+		}
+	}
+}
+
+// sample returns the Notification message of the values sampled in n for the
+// client of the member. n is not modified.
+func (m *sampleMember) sample(s *Server, n *pb.Notification) *pb.Notification {
+	mn := &pb.Notification{Timestamp: n.GetTimestamp(), Update: n.GetUpdate()}
+	if m.c.filter != nil {
+		mn.Update = filterUpdates(mn.GetUpdate(), m.c.filter)
+	}
+	if m.sub.GetMode() == pb.SubscriptionMode_TARGET_DEFINED {
+		// The leaves streamed on change are not sampled.
+		mn.Update = filterUpdates(mn.GetUpdate(), s.targetDefinedFilter(pb.SubscriptionMode_SAMPLE))
+	}
+	return mn
+}
+
+// sampleUpdates returns a Notification message for the path, without updates
+// if no data is found: the data of a STREAM subscription may be created, and
+// the wildcards of its path match new list entries, after it is made.
+func (s *Server) sampleUpdates(fullPath *pb.Path) (*pb.Notification, error) {
+	n, err := s.subscriptionUpdates(fullPath)
+	if status.Code(err) == codes.NotFound {
+		return &pb.Notification{Timestamp: n.GetTimestamp()}, nil
+	}
+	return n, err
+}
+
+// insert pushes the message in the queue of the client, unless the client is
+// disconnected as a slow consumer because its queue is full.
+func (c *streamClient) insert(msg interface{}) {
+	if atomic.LoadInt32(&c.slow) != 0 {
+		return
+	}
+	if c.queueLimit > 0 && c.msgQ.Len() >= c.queueLimit {
+		if atomic.CompareAndSwapInt32(&c.slow, 0, 1) {
+			if c.onSlow != nil {
+				c.onSlow()
+			}
+			select {
+			case c.errC <- status.Errorf(codes.ResourceExhausted, "subscriber too slow: %d messages queued", c.msgQ.Len()):
+			default:
+			}
+		}
+		return
+	}
+	c.msgQ.Insert(msg)
+}
+
+// subscriptionDiagnostics returns the counters of the STREAM subscriptions as
+// IETF JSON: the clients connected, the groups of SAMPLE subscriptions
+// running and the clients disconnected as slow consumers. All the counters
+// are encoded as strings, as the 64-bit integers of IETF JSON are.
+func (s *Server) subscriptionDiagnostics() (*pb.TypedValue, error) {
+	b, err := json.Marshal(map[string]interface{}{
+		"stream-clients":              strconv.FormatInt(s.streamClients.Load(), 10),
+		"sample-groups":               strconv.Itoa(s.sampler.groupCount()),
+		"slow-consumers-disconnected": strconv.FormatUint(s.slowConsumers.Load(), 10),
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error in marshaling diagnostics to JSON: %v", err)
+	}
+	return &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: b}}, nil
+}
@@ -24,32 +24,40 @@ import (
 	"io"
 	"io/ioutil"
 	"reflect"
-	"strconv"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
 	log "github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
 	"github.com/openconfig/gnmi/coalesce"
 	"github.com/openconfig/gnmi/value"
+	"github.com/openconfig/goyang/pkg/yang"
 	"github.com/openconfig/ygot/util"
 	"github.com/openconfig/ygot/ygot"
 	"github.com/openconfig/ygot/ytypes"
 
 	dpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
 	pb "github.com/openconfig/gnmi/proto/gnmi"
+	extpb "github.com/openconfig/gnmi/proto/gnmi_ext"
 )
 
 // ConfigCallback is the signature of the function to apply a validated config to the physical device.
 type ConfigCallback func(ygot.ValidatedGoStruct) error
 
+// ConfigDiffCallback is the signature of the function to apply the changes
+// from oldConfig to the validated newConfig to the physical device. diff holds
+// the leaves updated and the paths deleted by the changes.
+type ConfigDiffCallback func(oldConfig, newConfig ygot.ValidatedGoStruct, diff []*pb.Notification) error
+
 var (
-	pbRootPath         = &pb.Path{}
-	supportedEncodings = []pb.Encoding{pb.Encoding_JSON, pb.Encoding_JSON_IETF}
+	supportedEncodings = []pb.Encoding{pb.Encoding_JSON, pb.Encoding_JSON_IETF, pb.Encoding_PROTO}
 	subscribeSync      = &pb.SubscribeResponse{Response: &pb.SubscribeResponse_SyncResponse{SyncResponse: true}}
 )
 
@@ -79,107 +87,166 @@ const (
 //			// Do something ...
 //	}
 type Server struct {
-	model    *Model
-	callback ConfigCallback
+	model        *Model
+	callback     ConfigCallback
+	diffCallback ConfigDiffCallback
 
 	config ygot.ValidatedGoStruct
-	mu     sync.RWMutex // mu is the RW lock to protect the access to config
+	mu     sync.RWMutex // mu serializes the writers, and protects the access to config
+	snap   atomic.Value // snap holds the *snapshot read by Get and Subscribe
+
+	subMu      sync.Mutex // subMu protects the access to changeSubs
+	changeSubs map[*changeSubscriber]bool
+
+	targetDefinedRules []TargetDefinedRule
+	isOperational      func(*yang.Entry) bool
+	origins            map[string]OriginHandler
+	commit             *pendingCommit            // commit waiting for confirmation, protected by mu.
+	electionIDs        map[string]*extpb.Uint128 // election ID of the primary of every role, replaced under mu.
+	historySize        int
+	history            []*revision // committed config revisions from the oldest, protected by mu.
+	lastRevisionID     uint64      // ID of the last committed revision, protected by mu.
+	persistPath        string      // file persisting the running config, if set.
+	authorizer         PathAuthorizer
+	appliedState       bool
+	appliedStateDelay  time.Duration
+	appliedSeq         uint64                 // sequence number of the last delayed applied state, protected by mu.
+	appliedPending     []appliedChanges       // delayed applied state in the order of the commits, protected by mu.
+	state              ygot.ValidatedGoStruct // state pushed by UpdateState, protected by mu.
+	sampler            *sampler
+	queueLimit         int
+	streamClients      atomic.Int64  // number of STREAM clients connected.
+	slowConsumers      atomic.Uint64 // number of clients disconnected as slow consumers.
+}
+
+// ServerOpt is an option to customize a Server created by NewServer.
+type ServerOpt func(*Server)
+
+// WithConfigDiffCallback sets the callback receiving the changes of every
+// validated config, which is called after the ConfigCallback, if any.
+func WithConfigDiffCallback(callback ConfigDiffCallback) ServerOpt {
+	return func(s *Server) {
+		s.diffCallback = callback
+	}
 }
 
 // NewServer creates an instance of Server with given json config.
-func NewServer(model *Model, config []byte, callback ConfigCallback) (*Server, error) {
-	rootStruct, err := model.NewConfigStruct(config)
+func NewServer(model *Model, config []byte, callback ConfigCallback, opts ...ServerOpt) (*Server, error) {
+	s := &Server{
+		model:              model,
+		callback:           callback,
+		changeSubs:         make(map[*changeSubscriber]bool),
+		targetDefinedRules: DefaultTargetDefinedRules,
+		isOperational:      isOperational,
+		origins:            make(map[string]OriginHandler),
+		electionIDs:        make(map[string]*extpb.Uint128),
+	}
+	s.origins[DiagnosticsOrigin] = diagnosticsHandler{s: s}
+	s.sampler = newSampler(s)
+	for _, opt := range opts {
+		opt(s)
+	}
+	rootStruct, config, err := s.bootConfig(config)
 	if err != nil {
 		return nil, err
 	}
-	s := &Server{
-		model:    model,
-		config:   rootStruct,
-		callback: callback,
+	s.config = rootStruct
+	emptyStruct, err := model.NewConfigStruct(nil)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := diffConfigs(emptyStruct, rootStruct)
+	if err != nil {
+		return nil, fmt.Errorf("error in computing the config changes: %v", err)
 	}
-	if config != nil && s.callback != nil {
-		if err := s.callback(rootStruct); err != nil {
+	if config != nil && (s.callback != nil || s.diffCallback != nil) {
+		if err := s.applyConfig(emptyStruct, rootStruct, diff); err != nil {
 			return nil, err
 		}
 	}
+	s.recordRevision(rootStruct, "", diff)
+	if err := s.publishSnapshot(); err != nil {
+		return nil, err
+	}
+	if s.appliedState {
+		// The device boots with the startup config applied.
+		if changes := s.appliedStateChanges(diff); changes != nil {
+			s.applyStateChanges(changes)
+		}
+	}
 	return s, nil
 }
 
-// checkEncodingAndModel checks whether encoding and models are supported by the server. Return error if anything is unsupported.
-func (s *Server) checkEncodingAndModel(encoding pb.Encoding, models []*pb.ModelData) error {
-	hasSupportedEncoding := false
+// applyConfig applies newConfig, replacing oldConfig, to the device with the
+// callbacks of the server. diff holds the changes from oldConfig to newConfig,
+// and is computed if nil.
+func (s *Server) applyConfig(oldConfig, newConfig ygot.ValidatedGoStruct, diff *pb.Notification) error {
+	if s.callback != nil {
+		if err := s.callback(newConfig); err != nil {
+			return err
+		}
+	}
+	if s.diffCallback == nil {
+		return nil
+	}
+	if diff == nil {
+		var err error
+		if diff, err = diffConfigs(oldConfig, newConfig); err != nil {
+			return fmt.Errorf("error in computing the config changes: %v", err)
+		}
+	}
+	return s.diffCallback(oldConfig, newConfig, []*pb.Notification{diff})
+}
+
+// checkEncoding checks whether encoding is supported by the server. Return error if it is unsupported.
+func checkEncoding(encoding pb.Encoding) error {
 	for _, supportedEncoding := range supportedEncodings {
 		if encoding == supportedEncoding {
-			hasSupportedEncoding = true
-			break
+			return nil
 		}
 	}
-	if !hasSupportedEncoding {
-		return fmt.Errorf("unsupported encoding: %s", pb.Encoding_name[int32(encoding)])
+	return fmt.Errorf("unsupported encoding: %s", pb.Encoding_name[int32(encoding)])
+}
+
+// modelFilter returns the leafFilter keeping the data of the models, or nil if
+// no model is given. The models are matched by name, and by organization and
+// version when set. Return error if a model is unsupported.
+func (s *Server) modelFilter(models []*pb.ModelData) (leafFilter, error) {
+	if len(models) == 0 {
+		return nil, nil
 	}
+	names := make(map[string]bool)
 	for _, m := range models {
 		isSupported := false
 		for _, supportedModel := range s.model.modelData {
-			if reflect.DeepEqual(m, supportedModel) {
+			if m.GetName() == supportedModel.GetName() &&
+				(m.GetOrganization() == "" || m.GetOrganization() == supportedModel.GetOrganization()) &&
+				(m.GetVersion() == "" || m.GetVersion() == supportedModel.GetVersion()) {
 				isSupported = true
 				break
 			}
 		}
 		if !isSupported {
-			return fmt.Errorf("unsupported model: %v", m)
+			return nil, fmt.Errorf("unsupported model: %v", m)
 		}
+		names[m.GetName()] = true
 	}
-	return nil
+	return func(_ *pb.Path, e *yang.Entry) bool {
+		return names[s.model.moduleOf(e)]
+	}, nil
 }
 
-// doDelete deletes the path from the json tree if the path exists. If success,
-// it calls the callback function to apply the change to the device hardware.
-func (s *Server) doDelete(jsonTree map[string]interface{}, prefix, path *pb.Path) (*pb.UpdateResult, error) {
-	// Update json tree of the device config
-	var curNode interface{} = jsonTree
-	pathDeleted := false
+// doDelete deletes the path from the config if the path exists.
+func (s *Server) doDelete(config ygot.ValidatedGoStruct, prefix, path *pb.Path) (*pb.UpdateResult, error) {
 	fullPath := gnmiFullPath(prefix, path)
-	schema := s.model.schemaTreeRoot
-	for i, elem := range fullPath.Elem { // Delete sub-tree or leaf node.
-		node, ok := curNode.(map[string]interface{})
-		if !ok {
-			break
-		}
-
-		// Delete node
-		if i == len(fullPath.Elem)-1 {
-			if elem.GetKey() == nil {
-				delete(node, elem.Name)
-				pathDeleted = true
-				break
-			}
-			pathDeleted = deleteKeyedListEntry(node, elem)
-			break
-		}
-
-		if curNode, schema = getChildNode(node, schema, elem, false); curNode == nil {
-			break
-		}
-	}
-	if reflect.DeepEqual(fullPath, pbRootPath) { // Delete root
-		for k := range jsonTree {
-			delete(jsonTree, k)
-		}
-	}
-
-	// Apply the validated operation to the config tree and device.
-	if pathDeleted {
-		newConfig, err := s.toGoStruct(jsonTree)
-		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
-		}
-		if s.callback != nil {
-			if applyErr := s.callback(newConfig); applyErr != nil {
-				if rollbackErr := s.callback(s.config); rollbackErr != nil {
-					return nil, status.Errorf(codes.Internal, "error in rollback the failed operation (%v): %v", applyErr, rollbackErr)
-				}
-				return nil, status.Errorf(codes.Aborted, "error in applying operation to device: %v", applyErr)
-			}
+	switch {
+	case len(fullPath.GetElem()) == 0: // Delete root.
+		clearStruct(config)
+	case !s.model.isDataPath(fullPath):
+		// There is nothing to delete at a path out of the schema.
+	default:
+		if err := ytypes.DeleteNode(s.model.schemaTreeRoot, config, fullPath); err != nil {
+			return nil, status.Errorf(codes.NotFound, "path %v is not found in the config structure: %v", fullPath, err)
 		}
 	}
 	return &pb.UpdateResult{
@@ -189,111 +256,139 @@ func (s *Server) doDelete(jsonTree map[string]interface{}, prefix, path *pb.Path
 }
 
 // doReplaceOrUpdate validates the replace or update operation to be applied to
-// the device, modifies the json tree of the config struct, then calls the
-// callback function to apply the operation to the device hardware.
-func (s *Server) doReplaceOrUpdate(jsonTree map[string]interface{}, op pb.UpdateResult_Operation, prefix, path *pb.Path, val *pb.TypedValue) (*pb.UpdateResult, error) {
+// the device, then modifies the config. The config is validated as a whole
+// once all the operations of the SetRequest are applied.
+func (s *Server) doReplaceOrUpdate(config ygot.ValidatedGoStruct, op pb.UpdateResult_Operation, prefix, path *pb.Path, val *pb.TypedValue) (*pb.UpdateResult, error) {
+	result := &pb.UpdateResult{
+		Path: path,
+		Op:   op,
+	}
 	// Validate the operation.
 	fullPath := gnmiFullPath(prefix, path)
 	emptyNode, _, err := ytypes.GetOrCreateNode(s.model.schemaTreeRoot, s.model.newRootValue(), fullPath)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "path %v is not found in the config structure: %v", fullPath, err)
 	}
-	var nodeVal interface{}
 	nodeStruct, ok := emptyNode.(ygot.ValidatedGoStruct)
-	if ok {
-		if err := s.model.jsonUnmarshaler(val.GetJsonIetfVal(), nodeStruct); err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "unmarshaling json data to config struct fails: %v", err)
+	if !ok {
+		// Set the leaf or leaf-list value as JSON, which converts numbers to
+		// the type of the node.
+		scalar, err := value.ToScalar(val)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot convert leaf node to scalar type: %v", err)
 		}
-		if err := nodeStruct.Validate(); err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "config data validation fails: %v", err)
+		jsonVal, err := json.Marshal(scalar)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "error in marshaling leaf value %v: %v", val, err)
 		}
-		var err error
-		if nodeVal, err = ygot.ConstructIETFJSON(nodeStruct, &ygot.RFC7951JSONConfig{}); err != nil {
-			msg := fmt.Sprintf("error in constructing IETF JSON tree from config struct: %v", err)
-			log.Error(msg)
-			return nil, status.Error(codes.Internal, msg)
+		val = &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: jsonVal}}
+		if err := ytypes.SetNode(s.model.schemaTreeRoot, config, fullPath, val, &ytypes.InitMissingElements{}); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "error in setting %v in config structure: %v", fullPath, err)
 		}
-	} else {
-		var err error
-		if nodeVal, err = value.ToScalar(val); err != nil {
-			return nil, status.Errorf(codes.Internal, "cannot convert leaf node to scalar type: %v", err)
+		return result, nil
+	}
+	// The keys of a list entry are set from the path, and must not change.
+	keys, err := ygot.PathKeyFromStruct(reflect.ValueOf(nodeStruct))
+	if err != nil {
+		keys = nil
+	}
+	if err := s.model.jsonUnmarshaler(val.GetJsonIetfVal(), nodeStruct); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "unmarshaling json data to config struct fails: %v", err)
+	}
+	if err := nodeStruct.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "config data validation fails: %v", err)
+	}
+	if keys != nil {
+		if newKeys, err := ygot.PathKeyFromStruct(reflect.ValueOf(nodeStruct)); err != nil || !reflect.DeepEqual(keys, newKeys) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid config data: the keys of %v are path attributes", fullPath)
 		}
 	}
+	if len(fullPath.GetElem()) == 0 && op == pb.UpdateResult_UPDATE {
+		return nil, status.Error(codes.Unimplemented, "update the root of config tree is unsupported")
+	}
 
-	// Update json tree of the device config.
-	var curNode interface{} = jsonTree
-	schema := s.model.schemaTreeRoot
-	for i, elem := range fullPath.Elem {
-		switch node := curNode.(type) {
-		case map[string]interface{}:
-			// Set node value.
-			if i == len(fullPath.Elem)-1 {
-				if elem.GetKey() == nil {
-					if grpcStatusError := setPathWithoutAttribute(op, node, elem, nodeVal); grpcStatusError != nil {
-						return nil, grpcStatusError
-					}
-					break
-				}
-				if grpcStatusError := setPathWithAttribute(op, node, elem, nodeVal); grpcStatusError != nil {
-					return nil, grpcStatusError
-				}
-				break
-			}
+	node, _, err := ytypes.GetOrCreateNode(s.model.schemaTreeRoot, config, fullPath)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "path %v is not found in the config structure: %v", fullPath, err)
+	}
+	nodeConfig, ok := node.(ygot.GoStruct)
+	if !ok {
+		return nil, status.Errorf(codes.Internal, "wrong node type: %T", node)
+	}
+	setStruct(nodeConfig, nodeStruct, op == pb.UpdateResult_REPLACE)
+	return result, nil
+}
 
-			if curNode, schema = getChildNode(node, schema, elem, true); curNode == nil {
-				return nil, status.Errorf(codes.NotFound, "path elem not found: %v", elem)
-			}
-		case []interface{}:
-			return nil, status.Errorf(codes.NotFound, "incompatible path elem: %v", elem)
-		default:
-			return nil, status.Errorf(codes.Internal, "wrong node type: %T", curNode)
-		}
+// setStruct replaces the fields of dst by the fields of src, of the same type.
+// Unless replace is set, only the fields set in src are replaced.
+func setStruct(dst, src ygot.GoStruct, replace bool) {
+	dv, sv := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	if replace {
+		dv.Set(sv)
+		return
 	}
-	if reflect.DeepEqual(fullPath, pbRootPath) { // Replace/Update root.
-		if op == pb.UpdateResult_UPDATE {
-			return nil, status.Error(codes.Unimplemented, "update the root of config tree is unsupported")
-		}
-		nodeValAsTree, ok := nodeVal.(map[string]interface{})
-		if !ok {
-			return nil, status.Errorf(codes.InvalidArgument, "expect a tree to replace the root, got a scalar value: %T", nodeVal)
-		}
-		for k := range jsonTree {
-			delete(jsonTree, k)
-		}
-		for k, v := range nodeValAsTree {
-			jsonTree[k] = v
+	for i := 0; i < sv.NumField(); i++ {
+		if f := sv.Field(i); !f.IsZero() {
+			dv.Field(i).Set(f)
 		}
 	}
-	newConfig, err := s.toGoStruct(jsonTree)
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
+}
 
-	// Apply the validated operation to the device.
-	if s.callback != nil {
-		if applyErr := s.callback(newConfig); applyErr != nil {
-			if rollbackErr := s.callback(s.config); rollbackErr != nil {
-				return nil, status.Errorf(codes.Internal, "error in rollback the failed operation (%v): %v", applyErr, rollbackErr)
-			}
-			return nil, status.Errorf(codes.Aborted, "error in applying operation to device: %v", applyErr)
+// clearStruct resets all the fields of s.
+func clearStruct(s ygot.GoStruct) {
+	v := reflect.ValueOf(s).Elem()
+	v.Set(reflect.Zero(v.Type()))
+}
+
+// commitConfig applies the validated newConfig to the device, at once for the
+// whole transaction, then replaces the config of the server, records the
+// revision committed by user and publishes the changes of diff. The device is
+// rolled back to the current config on failure.
+func (s *Server) commitConfig(newConfig ygot.ValidatedGoStruct, user string, diff *pb.Notification) error {
+	if applyErr := s.applyConfig(s.config, newConfig, diff); applyErr != nil {
+		if rollbackErr := s.applyConfig(newConfig, s.config, nil); rollbackErr != nil {
+			return status.Errorf(codes.Internal, "error in rollback the failed transaction (%v): %v", applyErr, rollbackErr)
 		}
+		return status.Errorf(codes.Aborted, "error in applying transaction to device: %v", applyErr)
 	}
-	return &pb.UpdateResult{
-		Path: path,
-		Op:   op,
-	}, nil
+	s.config = newConfig
+	s.recordRevision(newConfig, user, diff)
+	// The config of a commit waiting for confirmation is not persisted, so
+	// that a restarted target boots with the config it rolls back to.
+	persisted := newConfig
+	if s.commit != nil {
+		persisted = s.commit.oldConfig
+	}
+	if err := s.persistConfig(persisted); err != nil {
+		// The config is applied to the device, so only log the failure.
+		log.Errorf("error in persisting the running config: %v", err)
+	}
+	if err := s.publishSnapshot(); err != nil {
+		log.Errorf("error in publishing the config snapshot: %v", err)
+	}
+	if diff != nil {
+		s.publishChanges(diff)
+	}
+	if s.appliedState {
+		s.reflectAppliedState(diff)
+	}
+	return nil
 }
 
-func (s *Server) toGoStruct(jsonTree map[string]interface{}) (ygot.ValidatedGoStruct, error) {
-	jsonDump, err := json.Marshal(jsonTree)
-	if err != nil {
-		return nil, fmt.Errorf("error in marshaling IETF JSON tree to bytes: %v", err)
+// doSetOp applies the delete, replace or update operation to the config, with
+// the handler of the origin of the path if any.
+func (s *Server) doSetOp(config ygot.ValidatedGoStruct, op pb.UpdateResult_Operation, prefix *pb.Path, upd *pb.Update) (*pb.UpdateResult, error) {
+	h, grpcStatusError := s.originHandler(prefix, upd.GetPath())
+	if grpcStatusError != nil {
+		return nil, grpcStatusError
 	}
-	goStruct, err := s.model.NewConfigStruct(jsonDump)
-	if err != nil {
-		return nil, fmt.Errorf("error in creating config struct from IETF JSON data: %v", err)
+	switch {
+	case h != nil:
+		return s.doOriginSet(config, h, op, prefix, upd.GetPath(), upd.GetVal())
+	case op == pb.UpdateResult_DELETE:
+		return s.doDelete(config, prefix, upd.GetPath())
 	}
-	return goStruct, nil
+	return s.doReplaceOrUpdate(config, op, prefix, upd.GetPath(), upd.GetVal())
 }
 
 // getGNMIServiceVersion returns a pointer to the gNMI service version string.
@@ -320,59 +415,16 @@ func getGNMIServiceVersion() (*string, error) {
 	return ver.(*string), nil
 }
 
-// deleteKeyedListEntry deletes the keyed list entry from node that matches the
-// path elem. If the entry is the only one in keyed list, deletes the entire
-// list. If the entry is found and deleted, the function returns true. If it is
-// not found, the function returns false.
-func deleteKeyedListEntry(node map[string]interface{}, elem *pb.PathElem) bool {
-	curNode, ok := node[elem.Name]
-	if !ok {
-		return false
-	}
-
-	keyedList, ok := curNode.([]interface{})
-	if !ok {
-		return false
-	}
-	for i, n := range keyedList {
-		m, ok := n.(map[string]interface{})
-		if !ok {
-			log.Errorf("expect map[string]interface{} for a keyed list entry, got %T", n)
-			return false
-		}
-		keyMatching := true
-		for k, v := range elem.Key {
-			attrVal, ok := m[k]
-			if !ok {
-				return false
-			}
-			if v != fmt.Sprintf("%v", attrVal) {
-				keyMatching = false
-				break
-			}
-		}
-		if keyMatching {
-			listLen := len(keyedList)
-			if listLen == 1 {
-				delete(node, elem.Name)
-				return true
-			}
-			keyedList[i] = keyedList[listLen-1]
-			node[elem.Name] = keyedList[0 : listLen-1]
-			return true
-		}
-	}
-	return false
-}
-
-// gnmiFullPath builds the full path from the prefix and path.
+// gnmiFullPath builds the full path from the prefix and path. The elems are
+// copied, as the full path may outlive the request and the prefix may have
+// room left for the elems of path.
 func gnmiFullPath(prefix, path *pb.Path) *pb.Path {
 	fullPath := &pb.Path{Origin: path.Origin}
 	if path.GetElement() != nil {
-		fullPath.Element = append(prefix.GetElement(), path.GetElement()...)
+		fullPath.Element = append(append([]string{}, prefix.GetElement()...), path.GetElement()...)
 	}
 	if path.GetElem() != nil {
-		fullPath.Elem = append(prefix.GetElem(), path.GetElem()...)
+		fullPath.Elem = append(append([]*pb.PathElem{}, prefix.GetElem()...), path.GetElem()...)
 	}
 	return fullPath
 }
@@ -390,60 +442,6 @@ func isNil(i interface{}) bool {
 	}
 }
 
-// setPathWithAttribute replaces or updates a child node of curNode in the IETF
-// JSON config tree, where the child node is indexed by pathElem with attribute.
-// The function returns grpc status error if unsuccessful.
-func setPathWithAttribute(op pb.UpdateResult_Operation, curNode map[string]interface{}, pathElem *pb.PathElem, nodeVal interface{}) error {
-	nodeValAsTree, ok := nodeVal.(map[string]interface{})
-	if !ok {
-		return status.Errorf(codes.InvalidArgument, "expect nodeVal is a json node of map[string]interface{}, received %T", nodeVal)
-	}
-	m := getKeyedListEntry(curNode, pathElem, true)
-	if m == nil {
-		return status.Errorf(codes.NotFound, "path elem not found: %v", pathElem)
-	}
-	if op == pb.UpdateResult_REPLACE {
-		for k := range m {
-			delete(m, k)
-		}
-	}
-	for attrKey, attrVal := range pathElem.GetKey() {
-		m[attrKey] = attrVal
-		if asNum, err := strconv.ParseFloat(attrVal, 64); err == nil {
-			m[attrKey] = asNum
-		}
-		for k, v := range nodeValAsTree {
-			if k == attrKey && fmt.Sprintf("%v", v) != attrVal {
-				return status.Errorf(codes.InvalidArgument, "invalid config data: %v is a path attribute", k)
-			}
-		}
-	}
-	for k, v := range nodeValAsTree {
-		m[k] = v
-	}
-	return nil
-}
-
-// setPathWithoutAttribute replaces or updates a child node of curNode in the
-// IETF config tree, where the child node is indexed by pathElem without
-// attribute. The function returns grpc status error if unsuccessful.
-func setPathWithoutAttribute(op pb.UpdateResult_Operation, curNode map[string]interface{}, pathElem *pb.PathElem, nodeVal interface{}) error {
-	target, hasElem := curNode[pathElem.Name]
-	nodeValAsTree, nodeValIsTree := nodeVal.(map[string]interface{})
-	if op == pb.UpdateResult_REPLACE || !hasElem || !nodeValIsTree {
-		curNode[pathElem.Name] = nodeVal
-		return nil
-	}
-	targetAsTree, ok := target.(map[string]interface{})
-	if !ok {
-		return status.Errorf(codes.Internal, "error in setting path: expect map[string]interface{} to update, got %T", target)
-	}
-	for k, v := range nodeValAsTree {
-		targetAsTree[k] = v
-	}
-	return nil
-}
-
 // Capabilities returns supported encodings and supported models.
 func (s *Server) Capabilities(ctx context.Context, req *pb.CapabilityRequest) (*pb.CapabilityResponse, error) {
 	ver, err := getGNMIServiceVersion()
@@ -459,21 +457,74 @@ func (s *Server) Capabilities(ctx context.Context, req *pb.CapabilityRequest) (*
 
 // Get implements the Get RPC in gNMI spec.
 func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
-	if req.GetType() != pb.GetRequest_ALL {
-		return nil, status.Errorf(codes.Unimplemented, "unsupported request type: %s", pb.GetRequest_DataType_name[int32(req.GetType())])
+	dataTypeFilter, err := s.dataTypeFilter(req.GetType())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// The encoding of the paths of the origin handlers is up to the handlers.
+	encodingErr := checkEncoding(req.GetEncoding())
+	modelFilter, err := s.modelFilter(req.GetUseModels())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	user := UserFromContext(ctx)
+	filter := allFilters(dataTypeFilter, modelFilter, s.readFilter(user))
+	hist, err := historyExtension(req.GetExtension())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	if err := s.checkEncodingAndModel(req.GetEncoding(), req.GetUseModels()); err != nil {
-		return nil, status.Error(codes.Unimplemented, err.Error())
+	if hist.GetRange() != nil {
+		return nil, status.Error(codes.InvalidArgument, "history range is only supported by Subscribe")
+	}
+	depth, err := depthExtension(req.GetExtension())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	prefix := req.GetPrefix()
 	paths := req.GetPath()
 	notifications := make([]*pb.Notification, len(paths))
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	// Read the last committed config, or the config at the snapshot time of
+	// the History extension, if any.
+	snap := s.snapshot()
+	config := snap.tree
+	var rev *revision
+	if hist != nil {
+		if rev, err = snap.revisionAt(hist.GetSnapshotTime()); err != nil {
+			return nil, err
+		}
+		config = rev.config
+	}
 
 	for i, path := range paths {
+		ts := time.Now().UnixNano()
+		if rev != nil {
+			ts = rev.timestamp
+		}
+		h, err := s.originHandler(prefix, path)
+		if err != nil {
+			return nil, err
+		}
+		if h != nil {
+			if grpcStatusError := s.checkOriginAccess(user, prefix, path, false); grpcStatusError != nil {
+				return nil, grpcStatusError
+			}
+			val, err := h.Get(config, originPath(prefix, path))
+			if err != nil {
+				return nil, originError(err)
+			}
+			notifications[i] = &pb.Notification{
+				Timestamp: ts,
+				Prefix:    prefix,
+				Update:    []*pb.Update{{Path: path, Val: val}},
+			}
+			continue
+		}
+		if encodingErr != nil {
+			return nil, status.Error(codes.Unimplemented, encodingErr.Error())
+		}
+
 		// Get schema node for path from config struct.
 		fullPath := path
 		if prefix != nil {
@@ -482,148 +533,274 @@ func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse,
 		if fullPath.GetElem() == nil && fullPath.GetElement() != nil {
 			return nil, status.Error(codes.Unimplemented, "deprecated path element type is unsupported")
 		}
-		nodes, err := ytypes.GetNode(s.model.schemaTreeRoot, s.config, fullPath)
-		if len(nodes) == 0 || err != nil || util.IsValueNil(nodes[0].Data) {
-			return nil, status.Errorf(codes.NotFound, "path %v not found: %v", fullPath, err)
-		}
-		node := nodes[0].Data
-
-		ts := time.Now().UnixNano()
-
-		nodeStruct, ok := node.(ygot.GoStruct)
-		// Return leaf node.
-		if !ok {
-			var val *pb.TypedValue
-			switch kind := reflect.ValueOf(node).Kind(); kind {
-			case reflect.Ptr, reflect.Interface:
-				var err error
-				val, err = value.FromScalar(reflect.ValueOf(node).Elem().Interface())
-				if err != nil {
-					msg := fmt.Sprintf("leaf node %v does not contain a scalar type value: %v", path, err)
-					log.Error(msg)
-					return nil, status.Error(codes.Internal, msg)
-				}
-			case reflect.Int64:
-				enumMap, ok := s.model.enumData[reflect.TypeOf(node).Name()]
-				if !ok {
-					return nil, status.Error(codes.Internal, "not a GoStruct enumeration type")
-				}
-				val = &pb.TypedValue{
-					Value: &pb.TypedValue_StringVal{
-						StringVal: enumMap[reflect.ValueOf(node).Int()].Name,
-					},
-				}
-			default:
-				return nil, status.Errorf(codes.Internal, "unexpected kind of leaf node type: %v %v", node, kind)
+		if !hasWildcards(fullPath) {
+			updates, err := s.getUpdates(config, prefix, path, fullPath, req.GetEncoding(), filter, depth)
+			if err != nil {
+				return nil, err
 			}
-
-			update := &pb.Update{Path: path, Val: val}
 			notifications[i] = &pb.Notification{
 				Timestamp: ts,
 				Prefix:    prefix,
-				Update:    []*pb.Update{update},
+				Update:    updates,
 			}
 			continue
 		}
 
-		if req.GetUseModels() != nil {
-			return nil, status.Errorf(codes.Unimplemented, "filtering Get using use_models is unsupported, got: %v", req.GetUseModels())
+		// Return the updates of every data node matching the wildcards, with
+		// their paths resolved. The wildcards of the prefix are resolved in the
+		// paths of the updates.
+		respPrefix := prefix
+		if hasWildcards(prefix) {
+			respPrefix = &pb.Path{Origin: prefix.GetOrigin(), Target: prefix.GetTarget()}
 		}
-
-		// Return IETF JSON by default.
-		jsonEncoder := func() (map[string]interface{}, error) {
-			return ygot.ConstructIETFJSON(nodeStruct, &ygot.RFC7951JSONConfig{AppendModuleName: true})
+		matches, err := s.expandWildcards(config, fullPath)
+		if err != nil {
+			msg := fmt.Sprintf("error in resolving wildcards of path %v: %v", fullPath, err)
+			log.Error(msg)
+			return nil, status.Error(codes.Internal, msg)
 		}
-		jsonType := "IETF"
-		buildUpdate := func(b []byte) *pb.Update {
-			return &pb.Update{Path: path, Val: &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: b}}}
+		n := &pb.Notification{
+			Timestamp: ts,
+			Prefix:    respPrefix,
 		}
-
-		if req.GetEncoding() == pb.Encoding_JSON {
-			jsonEncoder = func() (map[string]interface{}, error) {
-				return ygot.ConstructInternalJSON(nodeStruct)
-			}
-			jsonType = "Internal"
-			buildUpdate = func(b []byte) *pb.Update {
-				return &pb.Update{Path: path, Val: &pb.TypedValue{Value: &pb.TypedValue_JsonVal{JsonVal: b}}}
+		for _, match := range matches {
+			resolved := &pb.Path{Elem: match.GetElem()[len(respPrefix.GetElem()):]}
+			updates, err := s.getUpdates(config, respPrefix, resolved, match, req.GetEncoding(), filter, depth)
+			if err != nil {
+				return nil, err
 			}
+			n.Update = append(n.Update, updates...)
 		}
+		notifications[i] = n
+	}
+
+	return &pb.GetResponse{Notification: notifications}, nil
+}
+
+// getUpdates returns the updates of the Get response for the node of config at
+// the concrete fullPath, which is path relative to prefix. If depth is not 0,
+// the subtrees more than depth levels below the node are truncated, and each
+// is reported by a truncatedUpdate.
+func (s *Server) getUpdates(config ygot.ValidatedGoStruct, prefix, path, fullPath *pb.Path, encoding pb.Encoding, filter leafFilter, depth uint32) ([]*pb.Update, error) {
+	nodes, err := ytypes.GetNode(s.model.schemaTreeRoot, config, fullPath)
+	if len(nodes) == 0 || err != nil || util.IsValueNil(nodes[0].Data) {
+		return nil, status.Errorf(codes.NotFound, "path %v not found: %v", fullPath, err)
+	}
+	node := nodes[0].Data
 
-		jsonTree, err := jsonEncoder()
+	nodeStruct, ok := node.(ygot.GoStruct)
+	// Return leaf node.
+	if !ok {
+		if filter != nil && !filter(fullPath, nodes[0].Schema) {
+			// The leaf is not of the requested data type or models.
+			return nil, nil
+		}
+		val, err := s.leafValue(node)
 		if err != nil {
-			msg := fmt.Sprintf("error in constructing %s JSON tree from requested node: %v", jsonType, err)
+			msg := fmt.Sprintf("leaf node %v does not contain a scalar type value: %v", path, err)
 			log.Error(msg)
 			return nil, status.Error(codes.Internal, msg)
 		}
 
-		jsonDump, err := json.Marshal(jsonTree)
+		update := &pb.Update{Path: path, Val: val}
+		if encoding == pb.Encoding_PROTO {
+			s.protoUpdates(prefix, []*pb.Update{update})
+		}
+		return []*pb.Update{update}, nil
+	}
+
+	// Return one update per leaf with PROTO encoding.
+	if encoding == pb.Encoding_PROTO {
+		updates, err := s.updatesFromNode(config, fullPath)
 		if err != nil {
-			msg := fmt.Sprintf("error in marshaling %s JSON tree to bytes: %v", jsonType, err)
+			msg := fmt.Sprintf("error in flattening node %v: %v", fullPath, err)
 			log.Error(msg)
 			return nil, status.Error(codes.Internal, msg)
 		}
+		if filter != nil {
+			updates = filterUpdates(updates, s.pathFilter(filter))
+		}
+		var truncated []*pb.Path
+		if depth > 0 {
+			if updates, truncated, err = truncateUpdates(updates, len(fullPath.GetElem()), depth); err != nil {
+				msg := fmt.Sprintf("error in truncating node %v: %v", fullPath, err)
+				log.Error(msg)
+				return nil, status.Error(codes.Internal, msg)
+			}
+		}
+		for _, u := range updates {
+			u.Path = &pb.Path{Elem: u.GetPath().GetElem()[len(prefix.GetElem()):]}
+		}
+		s.protoUpdates(prefix, updates)
+		for _, p := range truncated {
+			updates = append(updates, truncatedUpdate(&pb.Path{Elem: p.GetElem()[len(prefix.GetElem()):]}, encoding))
+		}
+		return updates, nil
+	}
 
-		update := buildUpdate(jsonDump)
-		notifications[i] = &pb.Notification{
-			Timestamp: ts,
-			Prefix:    prefix,
-			Update:    []*pb.Update{update},
+	if filter != nil {
+		if nodeStruct, err = s.pruneNode(nodeStruct, fullPath, nodes[0].Schema, filter); err != nil {
+			msg := fmt.Sprintf("error in filtering data of node %v: %v", fullPath, err)
+			log.Error(msg)
+			return nil, status.Error(codes.Internal, msg)
+		}
+	}
+	var truncated []*pb.Path
+	if depth > 0 {
+		if nodeStruct, truncated, err = truncateNode(nodeStruct, fullPath, nodes[0].Schema, depth); err != nil {
+			msg := fmt.Sprintf("error in truncating node %v: %v", fullPath, err)
+			log.Error(msg)
+			return nil, status.Error(codes.Internal, msg)
 		}
 	}
 
-	return &pb.GetResponse{Notification: notifications}, nil
+	// Return IETF JSON by default.
+	jsonEncoder := func() (map[string]interface{}, error) {
+		return ygot.ConstructIETFJSON(nodeStruct, &ygot.RFC7951JSONConfig{AppendModuleName: true})
+	}
+	jsonType := "IETF"
+	buildUpdate := func(b []byte) *pb.Update {
+		return &pb.Update{Path: path, Val: &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: b}}}
+	}
+
+	if encoding == pb.Encoding_JSON {
+		jsonEncoder = func() (map[string]interface{}, error) {
+			return ygot.ConstructInternalJSON(nodeStruct)
+		}
+		jsonType = "Internal"
+		buildUpdate = func(b []byte) *pb.Update {
+			return &pb.Update{Path: path, Val: &pb.TypedValue{Value: &pb.TypedValue_JsonVal{JsonVal: b}}}
+		}
+	}
+
+	jsonTree, err := jsonEncoder()
+	if err != nil {
+		msg := fmt.Sprintf("error in constructing %s JSON tree from requested node: %v", jsonType, err)
+		log.Error(msg)
+		return nil, status.Error(codes.Internal, msg)
+	}
+
+	jsonDump, err := json.Marshal(jsonTree)
+	if err != nil {
+		msg := fmt.Sprintf("error in marshaling %s JSON tree to bytes: %v", jsonType, err)
+		log.Error(msg)
+		return nil, status.Error(codes.Internal, msg)
+	}
+
+	updates := []*pb.Update{buildUpdate(jsonDump)}
+	for _, p := range truncated {
+		updates = append(updates, truncatedUpdate(&pb.Path{Elem: append(append([]*pb.PathElem{}, path.GetElem()...), p.GetElem()...)}, encoding))
+	}
+	return updates, nil
 }
 
 // Set implements the Set RPC in gNMI spec.
 func (s *Server) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse, error) {
+	return s.set(ctx, req, true)
+}
+
+// set applies the SetRequest, after checking its master arbitration
+// extension if arbitrate is true.
+func (s *Server) set(ctx context.Context, req *pb.SetRequest, arbitrate bool) (*pb.SetResponse, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	jsonTree, err := ygot.ConstructIETFJSON(s.config, &ygot.RFC7951JSONConfig{})
+	if arbitrate {
+		if grpcStatusError := s.checkArbitration(req); grpcStatusError != nil {
+			return nil, grpcStatusError
+		}
+	}
+	commit, err := commitExtension(req)
 	if err != nil {
-		msg := fmt.Sprintf("error in constructing IETF JSON tree from config struct: %v", err)
-		log.Error(msg)
-		return nil, status.Error(codes.Internal, msg)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if commit != nil && commit.GetCommit() == nil {
+		// Confirm, cancel or update the pending commit.
+		if grpcStatusError := s.doCommitAction(commit); grpcStatusError != nil {
+			return nil, grpcStatusError
+		}
+		return &pb.SetResponse{Prefix: req.GetPrefix()}, nil
+	}
+	if s.commit != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "commit %q is waiting for confirmation", s.commit.id)
+	}
+
+	// The operations are applied to a copy of the config, which replaces the
+	// config once validated and applied to the device.
+	rootStruct, err := s.copyConfig()
+	if err != nil {
+		log.Error(err)
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	prefix := req.GetPrefix()
+	user := UserFromContext(ctx)
+	if grpcStatusError := s.checkSetOriginAccess(user, req); grpcStatusError != nil {
+		return nil, grpcStatusError
+	}
 	var results []*pb.UpdateResult
 
 	for _, path := range req.GetDelete() {
-		res, grpcStatusError := s.doDelete(jsonTree, prefix, path)
+		res, grpcStatusError := s.doSetOp(rootStruct, pb.UpdateResult_DELETE, prefix, &pb.Update{Path: path})
 		if grpcStatusError != nil {
 			return nil, grpcStatusError
 		}
 		results = append(results, res)
 	}
 	for _, upd := range req.GetReplace() {
-		res, grpcStatusError := s.doReplaceOrUpdate(jsonTree, pb.UpdateResult_REPLACE, prefix, upd.GetPath(), upd.GetVal())
+		res, grpcStatusError := s.doSetOp(rootStruct, pb.UpdateResult_REPLACE, prefix, upd)
 		if grpcStatusError != nil {
 			return nil, grpcStatusError
 		}
 		results = append(results, res)
 	}
 	for _, upd := range req.GetUpdate() {
-		res, grpcStatusError := s.doReplaceOrUpdate(jsonTree, pb.UpdateResult_UPDATE, prefix, upd.GetPath(), upd.GetVal())
+		res, grpcStatusError := s.doSetOp(rootStruct, pb.UpdateResult_UPDATE, prefix, upd)
 		if grpcStatusError != nil {
 			return nil, grpcStatusError
 		}
 		results = append(results, res)
 	}
+	if len(req.GetUnionReplace()) > 0 {
+		res, grpcStatusError := s.doUnionReplace(rootStruct, prefix, req.GetUnionReplace())
+		if grpcStatusError != nil {
+			return nil, grpcStatusError
+		}
+		results = append(results, res...)
+	}
 
-	jsonDump, err := json.Marshal(jsonTree)
-	if err != nil {
-		msg := fmt.Sprintf("error in marshaling IETF JSON tree to bytes: %v", err)
-		log.Error(msg)
-		return nil, status.Error(codes.Internal, msg)
+	// Validate the config resulting from all the operations. The leafrefs
+	// are only checked where the changes may break them, as checking all of
+	// them walks the whole config.
+	ygot.PruneEmptyBranches(rootStruct)
+	shareUnionKeys(reflect.ValueOf(rootStruct))
+	if err := rootStruct.Validate(&ytypes.LeafrefOptions{IgnoreMissingData: true}); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "config data validation fails: %v", err)
 	}
-	rootStruct, err := s.model.NewConfigStruct(jsonDump)
+	diff, err := diffConfigs(s.config, rootStruct)
 	if err != nil {
-		msg := fmt.Sprintf("error in creating config struct from IETF JSON data: %v", err)
+		msg := fmt.Sprintf("error in computing the config changes: %v", err)
 		log.Error(msg)
 		return nil, status.Error(codes.Internal, msg)
 	}
-	s.config = rootStruct
+	if err := s.validateLeafrefs(s.config, rootStruct, diff); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "config data validation fails: %v", err)
+	}
+
+	if grpcStatusError := s.checkWriteAccess(user, diff); grpcStatusError != nil {
+		return nil, grpcStatusError
+	}
+
+	if commit.GetCommit() != nil {
+		s.startCommit(commit, s.config)
+	}
+	if grpcStatusError := s.commitConfig(rootStruct, user, diff); grpcStatusError != nil {
+		if s.commit != nil {
+			s.commit.timer.Stop()
+			s.commit = nil
+		}
+		return nil, grpcStatusError
+	}
 	return &pb.SetResponse{
 		Prefix:   req.GetPrefix(),
 		Response: results,
@@ -631,11 +808,32 @@ func (s *Server) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse,
 }
 
 // InternalUpdate is an experimental feature to let the server update its
-// internal states. Use it with your own risk.
+// internal states. Use it with your own risk. fp updates a copy of the config,
+// which then replaces the config. The changes made by fp are sent to the
+// ON_CHANGE subscribers.
 func (s *Server) InternalUpdate(fp func(config ygot.ValidatedGoStruct) error) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return fp(s.config)
+	config, err := s.copyConfig()
+	if err != nil {
+		return err
+	}
+	err = fp(config)
+	oldConfig := s.config
+	s.config = config
+	if snapErr := s.publishSnapshot(); snapErr != nil {
+		log.Errorf("error in publishing the config snapshot: %v", snapErr)
+	}
+	if !s.hasChangeSubscribers() {
+		return err
+	}
+	diff, diffErr := diffConfigs(oldConfig, config)
+	if diffErr != nil {
+		log.Errorf("error in computing the config changes: %v", diffErr)
+		return err
+	}
+	s.publishChanges(diff)
+	return err
 }
 
 // Set implements the Subscribe gNMI RPC.
@@ -658,15 +856,28 @@ func (s *Server) Subscribe(stream pb.GNMI_SubscribeServer) error {
 	if c.sr.GetSubscribe().GetAllowAggregation() {
 		return status.Error(codes.Unimplemented, "aggregation is not supported")
 	}
-	if c.sr.GetSubscribe().GetUseModels() != nil {
-		return status.Errorf(codes.Unimplemented, "subscription using use_models is not supported")
-	}
-
-	if err = s.checkEncodingAndModel(c.sr.GetSubscribe().GetEncoding(), nil); err != nil {
+	if err = checkEncoding(c.sr.GetSubscribe().GetEncoding()); err != nil {
 		return status.Error(codes.Unimplemented, err.Error())
 	}
+	modelFilter, err := s.modelFilter(c.sr.GetSubscribe().GetUseModels())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if filter := allFilters(modelFilter, s.readFilter(UserFromContext(stream.Context()))); filter != nil {
+		c.filter = s.pathFilter(filter)
+	}
+	hist, err := historyExtension(c.sr.GetExtension())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if c.depth, err = depthExtension(c.sr.GetExtension()); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	mode := c.sr.GetSubscribe().Mode
+	if c.depth > 0 && (mode != pb.SubscriptionList_ONCE || hist.GetRange() != nil) {
+		return status.Errorf(codes.InvalidArgument, "depth extension is only supported by ONCE subscriptions without history range")
+	}
 
 	// This error channel accepts errors from all goroutines spawned.
 	errC := make(chan error, 3)
@@ -675,16 +886,52 @@ func (s *Server) Subscribe(stream pb.GNMI_SubscribeServer) error {
 	c.msgQ = coalesce.NewQueue()
 	defer c.msgQ.Close()
 
+	if hist != nil {
+		// Replay the History of the subscribed paths.
+		var revs []*revision
+		snap := s.snapshot()
+		switch {
+		case hist.GetRange() != nil && mode != pb.SubscriptionList_POLL:
+			revs, err = snap.revisionsIn(hist.GetRange().GetStart(), hist.GetRange().GetEnd())
+		case hist.GetRange() == nil && mode == pb.SubscriptionList_ONCE:
+			var rev *revision
+			if rev, err = snap.revisionAt(hist.GetSnapshotTime()); err == nil {
+				revs = []*revision{rev}
+			}
+		default:
+			err = status.Errorf(codes.InvalidArgument, "history extension %v is not supported in mode %v", hist, mode)
+		}
+		if err != nil {
+			return err
+		}
+		go s.doHistoryReplay(c, revs)
+		go s.doSendSubscriptionMsgs(c)
+		return <-errC
+	}
+
 	switch mode {
 	case pb.SubscriptionList_ONCE:
 		go s.doOnceSubscription(c)
 	case pb.SubscriptionList_POLL:
-		return status.Error(codes.Unimplemented, "mode POLL is not implemented.")
+		go s.doPollSubscription(c)
 	case pb.SubscriptionList_STREAM:
+		s.streamClients.Add(1)
+		defer s.streamClients.Add(-1)
+		c.queueLimit = s.queueLimit
+		c.onSlow = func() {
+			s.slowConsumers.Add(1)
+			addr := "unknown"
+			if p, ok := peer.FromContext(stream.Context()); ok {
+				addr = p.Addr.String()
+			}
+			log.Warningf("disconnecting slow subscriber %s: %d messages queued", addr, c.msgQ.Len())
+		}
 
 		for _, sub := range c.sr.GetSubscribe().GetSubscription() {
-			// Check for only Sample subscriptions, with valid paths and interval value.
-			if mode := sub.GetMode(); mode != pb.SubscriptionMode_SAMPLE {
+			// Check for valid paths and interval value.
+			switch mode := sub.GetMode(); mode {
+			case pb.SubscriptionMode_SAMPLE, pb.SubscriptionMode_ON_CHANGE, pb.SubscriptionMode_TARGET_DEFINED:
+			default:
 				return status.Errorf(codes.Unimplemented, "subscription mode %v not implemented", mode)
 			}
 			interval := sub.GetSampleInterval()
@@ -699,17 +946,38 @@ func (s *Server) Subscribe(stream pb.GNMI_SubscribeServer) error {
 			if prefix != nil {
 				fullPath = gnmiFullPath(prefix, fullPath)
 			}
-			nodes, err := ytypes.GetNode(s.model.schemaTreeRoot, s.config, fullPath, &ytypes.GetHandleWildcards{})
-			if len(nodes) == 0 || err != nil || util.IsValueNil(nodes[0].Data) {
-				return status.Errorf(codes.InvalidArgument, "path %v not found: %v", fullPath, err)
+			// The path is checked against the schema only: the data may be
+			// created after the subscription.
+			if !s.model.isDataPath(fullPath) {
+				return status.Errorf(codes.InvalidArgument, "path %v not found in the schema", fullPath)
 			}
 		}
 		// Closing the done channel makes the spawed subroutines exit.
 		done := make(chan bool)
 		defer close(done)
+		var onChangeSubs, sampleSubs []*pb.Subscription
 		for _, sub := range c.sr.GetSubscribe().GetSubscription() {
+			// TARGET_DEFINED subscriptions stream some leaves on change and
+			// sample the others.
+			if mode := sub.GetMode(); mode == pb.SubscriptionMode_ON_CHANGE || mode == pb.SubscriptionMode_TARGET_DEFINED {
+				onChangeSubs = append(onChangeSubs, sub)
+			}
+			if mode := sub.GetMode(); mode == pb.SubscriptionMode_SAMPLE || mode == pb.SubscriptionMode_TARGET_DEFINED {
+				sampleSubs = append(sampleSubs, sub)
+			}
+		}
+		c.pendingSyncs = int32(len(sampleSubs))
+		if onChangeSubs != nil {
+			c.pendingSyncs++
+		}
+		for _, sub := range sampleSubs {
 			go s.doSampleSubscription(c, sub, done)
 		}
+		if onChangeSubs != nil {
+			cs := s.doOnChangeSubscription(c, onChangeSubs)
+			defer s.removeChangeSubscriber(cs)
+		}
+		go s.doRecvSubscriptionMsgs(c)
 	default:
 		return status.Errorf(codes.InvalidArgument, "subscription mode %v not recognized", mode)
 	}
@@ -723,45 +991,283 @@ type streamClient struct {
 	stream pb.GNMI_SubscribeServer
 	errC   chan<- error
 	msgQ   *coalesce.Queue
+
+	// filter, if set, restricts the leaves sent to the client to the
+	// requested models.
+	filter func(*pb.Path) bool
+
+	// depth, if not 0, is the level of the Depth extension of a ONCE
+	// subscription.
+	depth uint32
+
+	// pendingSyncs is the number of STREAM subscriptions yet to push their
+	// initial updates in the queue.
+	pendingSyncs int32
+
+	// queueLimit, if not 0, is the number of messages in the queue at which
+	// the client is disconnected as a slow consumer, and onSlow is called.
+	queueLimit int
+	onSlow     func()
+	slow       int32 // slow is set once the client is disconnected.
+}
+
+// truncate returns the updates of the subscribed fullPath truncated to the
+// depth of the client, followed by a truncatedUpdate for each truncated
+// subtree.
+func (c *streamClient) truncate(updates []*pb.Update, fullPath *pb.Path) ([]*pb.Update, error) {
+	updates, truncated, err := truncateUpdates(updates, len(fullPath.GetElem()), c.depth)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range truncated {
+		updates = append(updates, truncatedUpdate(p, c.sr.GetSubscribe().GetEncoding()))
+	}
+	return updates, nil
+}
+
+// syncDone is called by a STREAM subscription once its initial updates are
+// in the queue. The sync token is pushed after the last subscription is done.
+func (c *streamClient) syncDone() {
+	if atomic.AddInt32(&c.pendingSyncs, -1) <= 0 {
+		c.msgQ.Insert(subscribeSyncToken{})
+	}
 }
 
 // subscribeSyncToken signals doSendSubscriptionMsgs to send subscribeSync.
-type subscribeSyncToken struct{}
+// The poll sequence number keeps the queue from coalescing the tokens of
+// successive polls.
+type subscribeSyncToken struct {
+	poll uint64
+}
 
 // doSampleSubscription processes a STREAM Sampling Subscription.
-// It pushes Notification message in the queue.
-// On error or when the channel is closed, this routine exits.
+// It pushes the initial Notification message in the queue, then joins the
+// group of the subscriptions of the same path and intervals, sampled by the
+// sampler of the server, until the channel is closed.
+// The wildcards of the path are expanded at every sample, and the leaves
+// found in the previous sample but not in this one are pushed as deletes.
+// With suppress_redundant, only the values that changed since they were last
+// sent are pushed, and all of them are pushed again at each heartbeat.
 func (s *Server) doSampleSubscription(c *streamClient, sub *pb.Subscription, done <-chan bool) {
 	prefix := c.sr.GetSubscribe().GetPrefix()
 	fullPath := sub.GetPath()
 	if prefix != nil {
 		fullPath = gnmiFullPath(prefix, fullPath)
 	}
-	if !c.sr.GetSubscribe().GetUpdatesOnly() {
-		n, err := s.subscriptionUpdates(fullPath)
+	m := &sampleMember{c: c, sub: sub, st: newSampleState(sub)}
+	n, err := s.sampleUpdates(fullPath)
+	if err != nil {
+		log.Errorf("error in sampling path %v: %v", fullPath, err)
+		n = &pb.Notification{Timestamp: time.Now().UnixNano()}
+	}
+	if n = m.st.filter(m.sample(s, n), true); n != nil && !c.sr.GetSubscribe().GetUpdatesOnly() {
+		c.insert(n)
+	}
+	// With updates_only, the current values are known to the client even
+	// though they are not sent: only their changes and deletes are.
+	c.syncDone()
+
+	s.sampler.join(m, fullPath)
+	<-done
+	s.sampler.leave(m)
+}
+
+// sampleState keeps the leaves sampled for a client by a SAMPLE subscription,
+// to send the deletes of the leaves gone, and the values sent to suppress the
+// redundant ones.
+type sampleState struct {
+	suppressRedundant bool
+	sent              map[string]*pb.TypedValue
+	sampled           map[string]*pb.Path
+}
+
+func newSampleState(sub *pb.Subscription) *sampleState {
+	return &sampleState{
+		suppressRedundant: sub.GetSuppressRedundant(),
+		sent:              make(map[string]*pb.TypedValue),
+		sampled:           make(map[string]*pb.Path),
+	}
+}
+
+// filter returns the Notification message to send to the client for the
+// sampled values in n, with the deletes of the leaves sampled last time but
+// not in n. With suppress_redundant, it drops the updates of the leaves whose
+// value was already sent unless all is true. It returns nil if no update or
+// delete is left.
+func (st *sampleState) filter(n *pb.Notification, all bool) *pb.Notification {
+	var updates []*pb.Update
+	sampled := make(map[string]*pb.Path, len(n.GetUpdate()))
+	for _, u := range n.GetUpdate() {
+		key, err := ygot.PathToString(u.GetPath())
 		if err != nil {
-			return
+			updates = append(updates, u)
+			continue
+		}
+		sampled[key] = u.GetPath()
+		if !st.suppressRedundant {
+			updates = append(updates, u)
+			continue
+		}
+		if !all && proto.Equal(st.sent[key], u.GetVal()) {
+			continue
+		}
+		st.sent[key] = u.GetVal()
+		updates = append(updates, u)
+	}
+	var deletes []string
+	for key := range st.sampled {
+		if _, ok := sampled[key]; !ok {
+			deletes = append(deletes, key)
+		}
+	}
+	sort.Strings(deletes)
+	for _, key := range deletes {
+		n.Delete = append(n.Delete, st.sampled[key])
+		delete(st.sent, key)
+	}
+	st.sampled = sampled
+	if len(updates) == 0 && len(n.GetDelete()) == 0 {
+		return nil
+	}
+	n.Update = updates
+	return n
+}
+
+// changeSubscriber holds the paths of the ON_CHANGE subscriptions of a STREAM
+// client. A path may come with a filter restricting the leaves it covers.
+type changeSubscriber struct {
+	c       *streamClient
+	paths   []*pb.Path
+	filters []func(*pb.Path) bool
+}
+
+// covers returns true if path is at or below one of the subscribed paths.
+func (cs *changeSubscriber) covers(path *pb.Path) bool {
+	for i, p := range cs.paths {
+		if pathMatch(p.GetElem(), path.GetElem()) && (cs.filters[i] == nil || cs.filters[i](path)) {
+			return true
 		}
-		c.msgQ.Insert(n)
 	}
-	c.msgQ.Insert(subscribeSyncToken{})
+	return false
+}
 
-	updateInterval := time.Nanosecond * time.Duration(sub.GetSampleInterval())
-	if updateInterval == 0 {
-		updateInterval = minStreamSampleInterval
+// changes returns a Notification message with the leaves updated and the
+// paths deleted in diff covered by cs, or nil if there is none.
+func (cs *changeSubscriber) changes(diff *pb.Notification, ts int64) *pb.Notification {
+	n := &pb.Notification{Timestamp: ts}
+	for _, u := range diff.GetUpdate() {
+		if cs.covers(u.GetPath()) {
+			n.Update = append(n.Update, u)
+		}
 	}
-	updateTicker := time.NewTicker(updateInterval)
-	defer updateTicker.Stop()
-	for {
-		select {
-		case <-updateTicker.C:
-			n, err := s.subscriptionUpdates(fullPath)
+	for _, p := range diff.GetDelete() {
+		if cs.covers(p) {
+			n.Delete = append(n.Delete, p)
+		}
+	}
+	if len(n.Update) == 0 && len(n.Delete) == 0 {
+		return nil
+	}
+	return n
+}
+
+// doOnChangeSubscription processes the STREAM On Change Subscriptions of a
+// client. It pushes the current values of the subscribed paths and the
+// sync_response in the queue, then registers the client to receive the
+// changes made to the config. The caller must remove the returned
+// changeSubscriber when the client goes away.
+func (s *Server) doOnChangeSubscription(c *streamClient, subs []*pb.Subscription) *changeSubscriber {
+	cs := s.newChangeSubscriber(c, subs)
+
+	// Holding subMu makes sure no change is published between the snapshot
+	// of the initial updates and the registration of the subscriber. The
+	// writers publish their snapshot before their changes, so no change is
+	// missed.
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	if !c.sr.GetSubscribe().GetUpdatesOnly() {
+		tree := s.snapshot().tree
+		for i, fullPath := range cs.paths {
+			updates, err := s.updatesFromNode(tree, fullPath)
+			if status.Code(err) == codes.NotFound {
+				// The data may be created later.
+				continue
+			}
 			if err != nil {
-				return
+				log.Errorf("error in getting updates of path %v: %v", fullPath, err)
+				continue
 			}
-			c.msgQ.Insert(n)
-		case <-done:
-			return
+			if cs.filters[i] != nil {
+				if updates = filterUpdates(updates, cs.filters[i]); updates == nil {
+					continue
+				}
+			}
+			c.insert(&pb.Notification{
+				Timestamp: time.Now().UnixNano(),
+				Update:    updates,
+			})
+		}
+	}
+	c.syncDone()
+	s.changeSubs[cs] = true
+	return cs
+}
+
+// newChangeSubscriber returns the changeSubscriber of the subscriptions of the
+// client to the changes made to the config.
+func (s *Server) newChangeSubscriber(c *streamClient, subs []*pb.Subscription) *changeSubscriber {
+	prefix := c.sr.GetSubscribe().GetPrefix()
+	cs := &changeSubscriber{c: c}
+	for _, sub := range subs {
+		fullPath := sub.GetPath()
+		if prefix != nil {
+			fullPath = gnmiFullPath(prefix, fullPath)
+		}
+		filter := c.filter
+		if sub.GetMode() == pb.SubscriptionMode_TARGET_DEFINED {
+			onChange := s.targetDefinedFilter(pb.SubscriptionMode_ON_CHANGE)
+			if filter == nil {
+				filter = onChange
+			} else {
+				modelFilter := filter
+				filter = func(p *pb.Path) bool { return modelFilter(p) && onChange(p) }
+			}
+		}
+		cs.paths = append(cs.paths, fullPath)
+		cs.filters = append(cs.filters, filter)
+	}
+	return cs
+}
+
+// removeChangeSubscriber stops sending config changes to cs.
+func (s *Server) removeChangeSubscriber(cs *changeSubscriber) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	delete(s.changeSubs, cs)
+}
+
+// hasChangeSubscribers returns true if any client has an ON_CHANGE
+// subscription.
+func (s *Server) hasChangeSubscribers() bool {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	return len(s.changeSubs) > 0
+}
+
+// publishChanges pushes a Notification message with the leaves updated and
+// deleted by the config changes in diff in the queue of every ON_CHANGE
+// subscriber. Each subscriber only gets the leaves covered by its paths. The
+// message has the timestamp of diff, or the current time if it has none.
+func (s *Server) publishChanges(diff *pb.Notification) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	ts := diff.GetTimestamp()
+	if ts == 0 {
+		ts = time.Now().UnixNano()
+	}
+	for cs := range s.changeSubs {
+		if n := cs.changes(diff, ts); n != nil {
+			cs.c.insert(n)
 		}
 	}
 }
@@ -769,6 +1275,28 @@ func (s *Server) doSampleSubscription(c *streamClient, sub *pb.Subscription, don
 // doOnceSubscription processes a ONCE Subscription. It produces a single
 // Notification message for each Subscription.
 func (s *Server) doOnceSubscription(c *streamClient) {
+	if err := s.insertSnapshot(c, subscribeSyncToken{}); err != nil {
+		c.errC <- err
+		return
+	}
+	c.msgQ.Close()
+}
+
+// doPollSubscription processes a POLL Subscription. It produces a single
+// Notification message for each Subscription and keeps the subscription open
+// for the Poll requests handled by doRecvSubscriptionMsgs.
+func (s *Server) doPollSubscription(c *streamClient) {
+	if err := s.insertSnapshot(c, subscribeSyncToken{}); err != nil {
+		c.errC <- err
+		return
+	}
+	s.doRecvSubscriptionMsgs(c)
+}
+
+// insertSnapshot pushes a Notification message with the current values of
+// each Subscription in the queue, unless only updates are requested, followed
+// by the sync token.
+func (s *Server) insertSnapshot(c *streamClient, sync subscribeSyncToken) error {
 	prefix := c.sr.GetSubscribe().GetPrefix()
 	if !c.sr.GetSubscribe().GetUpdatesOnly() {
 		for _, subscription := range c.sr.GetSubscribe().GetSubscription() {
@@ -778,14 +1306,54 @@ func (s *Server) doOnceSubscription(c *streamClient) {
 			}
 			n, err := s.subscriptionUpdates(fullPath)
 			if err != nil {
-				c.errC <- err
-				return
+				return err
+			}
+			if c.filter != nil {
+				n.Update = filterUpdates(n.GetUpdate(), c.filter)
+			}
+			if c.depth > 0 {
+				if n.Update, err = c.truncate(n.GetUpdate(), fullPath); err != nil {
+					return status.Errorf(codes.Internal, "error in truncating path %v: %v", fullPath, err)
+				}
 			}
 			c.msgQ.Insert(n)
 		}
 	}
-	c.msgQ.Insert(subscribeSyncToken{})
-	c.msgQ.Close()
+	c.msgQ.Insert(sync)
+	return nil
+}
+
+// doRecvSubscriptionMsgs reads the requests sent by the client once the
+// subscription is established. In POLL mode, each Poll request produces a new
+// snapshot of the subscribed paths. Any other request is an error, and so is a
+// Poll request in the other modes.
+func (s *Server) doRecvSubscriptionMsgs(c *streamClient) {
+	mode := c.sr.GetSubscribe().GetMode()
+	var polls uint64
+	for {
+		req, err := c.stream.Recv()
+		switch {
+		case err == io.EOF:
+			// The client is done sending, the subscription goes on.
+			return
+		case err != nil:
+			c.errC <- err
+			return
+		}
+		if req.GetPoll() == nil {
+			c.errC <- status.Errorf(codes.InvalidArgument, "unexpected request on an established subscription: %v", req)
+			return
+		}
+		if mode != pb.SubscriptionList_POLL {
+			c.errC <- status.Errorf(codes.InvalidArgument, "poll request on a subscription of mode %v", mode)
+			return
+		}
+		polls++
+		if err := s.insertSnapshot(c, subscribeSyncToken{poll: polls}); err != nil {
+			c.errC <- err
+			return
+		}
+	}
 }
 
 // doSendSubscriptionMsgs monitors the message queue and sends
@@ -826,9 +1394,7 @@ func (s *Server) doSendSubscriptionMsgs(c *streamClient) {
 
 // subscriptionUpdates returns a Notification message for the path.
 func (s *Server) subscriptionUpdates(fullPath *pb.Path) (*pb.Notification, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	updates, err := s.updatesFromNode(fullPath)
+	updates, err := s.updatesFromNode(s.snapshot().tree, fullPath)
 	return &pb.Notification{
 		Timestamp: time.Now().UnixNano(),
 		Update:    updates,
@@ -836,11 +1402,11 @@ func (s *Server) subscriptionUpdates(fullPath *pb.Path) (*pb.Notification, error
 }
 
 // updatesFromNode returns a list of Update messages for the leaf nodes found,
-// starting to walk the tree at the path.
-func (s *Server) updatesFromNode(fullPath *pb.Path) ([]*pb.Update, error) {
+// starting to walk the tree of config at the path.
+func (s *Server) updatesFromNode(config ygot.GoStruct, fullPath *pb.Path) ([]*pb.Update, error) {
 	var updates []*pb.Update
 
-	nodes, err := ytypes.GetNode(s.model.schemaTreeRoot, s.config, fullPath, &ytypes.GetHandleWildcards{})
+	nodes, err := ytypes.GetNode(s.model.schemaTreeRoot, config, fullPath, &ytypes.GetHandleWildcards{})
 	if len(nodes) == 0 || err != nil || util.IsValueNil(nodes[0].Data) {
 		return nil, status.Errorf(codes.NotFound, "path %v not found: %v", fullPath, err)
 	}
@@ -858,32 +1424,15 @@ func (s *Server) updatesFromNode(fullPath *pb.Path) ([]*pb.Update, error) {
 				return nil, err
 			}
 			for _, up := range n[0].Update {
-				up.Path = &pb.Path{Elem: append(node.Path.GetElem(), up.Path.GetElem()...)}
+				up.Path = &pb.Path{Elem: append(append([]*pb.PathElem{}, node.Path.GetElem()...), up.Path.GetElem()...)}
 			}
 			updates = append(updates, n[0].Update...)
 			continue
 		}
 
-		var val *pb.TypedValue
-		switch kind := reflect.ValueOf(data).Kind(); kind {
-		case reflect.Ptr, reflect.Interface:
-			var err error
-			val, err = value.FromScalar(reflect.ValueOf(data).Elem().Interface())
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "leaf node %v does not contain a scalar type value: %v", fullPath, err)
-			}
-		case reflect.Int64:
-			enumMap, ok := s.model.enumData[reflect.TypeOf(data).Name()]
-			if !ok {
-				return nil, status.Error(codes.Internal, "not a GoStruct enumeration type")
-			}
-			val = &pb.TypedValue{
-				Value: &pb.TypedValue_StringVal{
-					StringVal: enumMap[reflect.ValueOf(data).Int()].Name,
-				},
-			}
-		default:
-			return nil, status.Errorf(codes.Internal, "unexpected kind of leaf node type: %v %v", node, kind)
+		val, err := s.leafValue(data)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "leaf node %v does not contain a scalar type value: %v", fullPath, err)
 		}
 		updates = append(updates, &pb.Update{Path: node.Path, Val: val})
 	}
@@ -19,7 +19,12 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -30,8 +35,10 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	pb "github.com/openconfig/gnmi/proto/gnmi"
+	extpb "github.com/openconfig/gnmi/proto/gnmi_ext"
 
 	"github.com/google/gnxi/gnmi/modeldata"
 	"github.com/google/gnxi/gnmi/modeldata/gostruct"
@@ -214,9 +221,59 @@ func TestGet(t *testing.T) {
 								elem: <name: "name" >`,
 		wantRetCode: codes.NotFound,
 	}, {
-		desc:        "use of model data not supported",
+		desc: "root node filtered by model",
+		modelData: []*pb.ModelData{{
+			Name: modeldata.OpenconfigPlatformModel,
+		}},
+		wantRetCode: codes.OK,
+		wantRespVal: `{
+			"openconfig-platform:components": {
+				"component": [
+					{
+						"config": {
+							"name": "swpri1-1-1"
+						},
+						"name": "swpri1-1-1"
+					}
+				]
+			}
+		}`,
+	}, {
+		desc: "node augmented by another model",
+		textPbPath: `
+			elem: <name: "system" >
+		`,
+		modelData: []*pb.ModelData{{
+			Name:    modeldata.OpenconfigSystemModel,
+			Version: "0.2.0",
+		}},
+		wantRetCode: codes.OK,
+		wantRespVal: `{}`,
+	}, {
+		desc: "leaf node of another model",
+		textPbPath: `
+			elem: <name: "system" >
+			elem: <name: "openflow" >
+			elem: <name: "agent" >
+			elem: <name: "config" >
+			elem: <name: "max-backoff" >
+		`,
+		modelData: []*pb.ModelData{{
+			Name: modeldata.OpenconfigOpenflowModel,
+		}},
+		wantRetCode: codes.OK,
+		wantRespVal: uint64(10),
+	}, {
+		desc:        "unknown model data",
 		modelData:   []*pb.ModelData{{}},
-		wantRetCode: codes.Unimplemented,
+		wantRetCode: codes.InvalidArgument,
+	}, {
+		desc: "unknown model version",
+		modelData: []*pb.ModelData{{
+			Name:    modeldata.OpenconfigSystemModel,
+			Version: "9.9.9",
+		}},
+		wantRetCode: codes.InvalidArgument,
 	}}
 
 	for _, td := range tds {
@@ -226,6 +283,580 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestGetDataType(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-interfaces:interfaces": {
+			"interface": [
+				{
+					"name": "eth0",
+					"config": {
+						"name": "eth0",
+						"mtu": 1500
+					},
+					"state": {
+						"mtu": 1500,
+						"oper-status": "UP",
+						"counters": {
+							"in-octets": "100"
+						}
+					}
+				},
+				{
+					"name": "eth1",
+					"config": {
+						"name": "eth1"
+					}
+				}
+			]
+		}
+	}`
+
+	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+
+	tests := []struct {
+		desc        string
+		dataType    pb.GetRequest_DataType
+		textPbPath  string
+		wantRetCode codes.Code
+		wantRespVal interface{}
+	}{{
+		desc:     "config data",
+		dataType: pb.GetRequest_CONFIG,
+		textPbPath: `
+			elem: <name: "interfaces" >
+		`,
+		wantRetCode: codes.OK,
+		wantRespVal: `{
+			"openconfig-interfaces:interface": [
+				{
+					"name": "eth0",
+					"config": {"name": "eth0", "mtu": 1500}
+				},
+				{
+					"name": "eth1",
+					"config": {"name": "eth1"}
+				}
+			]
+		}`,
+	}, {
+		desc:     "state data",
+		dataType: pb.GetRequest_STATE,
+		textPbPath: `
+			elem: <name: "interfaces" >
+		`,
+		wantRetCode: codes.OK,
+		wantRespVal: `{
+			"openconfig-interfaces:interface": [
+				{
+					"name": "eth0",
+					"state": {
+						"mtu": 1500,
+						"oper-status": "UP",
+						"counters": {"in-octets": "100"}
+					}
+				}
+			]
+		}`,
+	}, {
+		desc:     "operational data",
+		dataType: pb.GetRequest_OPERATIONAL,
+		textPbPath: `
+			elem: <name: "interfaces" >
+			elem: <
+				name: "interface"
+				key: <key: "name" value: "eth0" >
+			>
+		`,
+		wantRetCode: codes.OK,
+		wantRespVal: `{
+			"openconfig-interfaces:name": "eth0",
+			"openconfig-interfaces:state": {
+				"oper-status": "UP",
+				"counters": {"in-octets": "100"}
+			}
+		}`,
+	}, {
+		desc:     "leaf of the requested data type",
+		dataType: pb.GetRequest_STATE,
+		textPbPath: `
+			elem: <name: "interfaces" >
+			elem: <
+				name: "interface"
+				key: <key: "name" value: "eth0" >
+			>
+			elem: <name: "state" >
+			elem: <name: "mtu" >
+		`,
+		wantRetCode: codes.OK,
+		wantRespVal: uint64(1500),
+	}, {
+		desc:     "leaf of another data type",
+		dataType: pb.GetRequest_CONFIG,
+		textPbPath: `
+			elem: <name: "interfaces" >
+			elem: <
+				name: "interface"
+				key: <key: "name" value: "eth0" >
+			>
+			elem: <name: "state" >
+			elem: <name: "mtu" >
+		`,
+		wantRetCode: codes.OK,
+	}, {
+		desc:        "unknown data type",
+		dataType:    pb.GetRequest_DataType(42),
+		wantRetCode: codes.InvalidArgument,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var pbPath pb.Path
+			if err := proto.UnmarshalText(test.textPbPath, &pbPath); err != nil {
+				t.Fatalf("error in unmarshaling path: %v", err)
+			}
+			resp, err := s.Get(nil, &pb.GetRequest{
+				Path:     []*pb.Path{&pbPath},
+				Type:     test.dataType,
+				Encoding: pb.Encoding_JSON_IETF,
+			})
+			if got := status.Code(err); got != test.wantRetCode {
+				t.Fatalf("got return code %v, want %v: %v", got, test.wantRetCode, err)
+			}
+			if err != nil {
+				return
+			}
+			updates := resp.GetNotification()[0].GetUpdate()
+			if test.wantRespVal == nil {
+				if len(updates) != 0 {
+					t.Errorf("got updates %v, want none", updates)
+				}
+				return
+			}
+			if len(updates) != 1 {
+				t.Fatalf("got %d updates in the notification, want 1", len(updates))
+			}
+			var gotVal, wantVal interface{}
+			if jsonVal := updates[0].GetVal().GetJsonIetfVal(); jsonVal != nil {
+				if err := json.Unmarshal(jsonVal, &gotVal); err != nil {
+					t.Fatalf("error in unmarshaling IETF JSON data to json container: %v", err)
+				}
+				if err := json.Unmarshal([]byte(test.wantRespVal.(string)), &wantVal); err != nil {
+					t.Fatalf("error in unmarshaling IETF JSON data to json container: %v", err)
+				}
+			} else {
+				if gotVal, err = value.ToScalar(updates[0].GetVal()); err != nil {
+					t.Fatalf("got: %v, want a scalar value", updates[0].GetVal())
+				}
+				wantVal = test.wantRespVal
+			}
+			if diff := cmp.Diff(wantVal, gotVal); diff != "" {
+				t.Errorf("response value diff (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestGetProto(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-platform:components": {
+			"component": [
+				{
+					"name": "cpu0",
+					"config": {"name": "cpu0"},
+					"state": {
+						"name": "cpu0",
+						"temperature": {"instant": "45.3", "alarm-status": false}
+					}
+				}
+			]
+		},
+		"openconfig-system:system": {
+			"dns": {
+				"config": {"search": ["foo.com", "bar.com"]}
+			}
+		}
+	}`
+
+	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+
+	tests := []struct {
+		desc         string
+		textPbPrefix string
+		textPbPath   string
+		wantUpdates  []*pb.Update
+	}{{
+		desc: "container flattened into leaves",
+		textPbPath: `
+			elem: <
+				name: "components"
+			>
+			elem: <
+				name: "component"
+				key: <key: "name" value: "cpu0" >
+			>
+			elem: <name: "state" >
+		`,
+		wantUpdates: []*pb.Update{{
+			Path: mustPath("/components/component[name=cpu0]/state/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "cpu0"}},
+		}, {
+			Path: mustPath("/components/component[name=cpu0]/state/temperature/instant"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_DecimalVal{DecimalVal: &pb.Decimal64{Digits: 453, Precision: 1}}},
+		}, {
+			Path: mustPath("/components/component[name=cpu0]/state/temperature/alarm-status"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_BoolVal{BoolVal: false}},
+		}},
+	}, {
+		desc:         "paths relative to the prefix",
+		textPbPrefix: `elem: <name: "system" >`,
+		textPbPath:   `elem: <name: "dns" >`,
+		wantUpdates: []*pb.Update{{
+			Path: mustPath("/dns/config/search"),
+			Val: &pb.TypedValue{Value: &pb.TypedValue_LeaflistVal{LeaflistVal: &pb.ScalarArray{Element: []*pb.TypedValue{
+				{Value: &pb.TypedValue_StringVal{StringVal: "foo.com"}},
+				{Value: &pb.TypedValue_StringVal{StringVal: "bar.com"}},
+			}}}},
+		}},
+	}, {
+		desc: "decimal64 leaf",
+		textPbPath: `
+			elem: <name: "components" >
+			elem: <
+				name: "component"
+				key: <key: "name" value: "cpu0" >
+			>
+			elem: <name: "state" >
+			elem: <name: "temperature" >
+			elem: <name: "instant" >
+		`,
+		wantUpdates: []*pb.Update{{
+			Path: mustPath("/components/component[name=cpu0]/state/temperature/instant"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_DecimalVal{DecimalVal: &pb.Decimal64{Digits: 453, Precision: 1}}},
+		}},
+	}, {
+		desc: "leaf-list",
+		textPbPath: `
+			elem: <name: "system" >
+			elem: <name: "dns" >
+			elem: <name: "config" >
+			elem: <name: "search" >
+		`,
+		wantUpdates: []*pb.Update{{
+			Path: mustPath("/system/dns/config/search"),
+			Val: &pb.TypedValue{Value: &pb.TypedValue_LeaflistVal{LeaflistVal: &pb.ScalarArray{Element: []*pb.TypedValue{
+				{Value: &pb.TypedValue_StringVal{StringVal: "foo.com"}},
+				{Value: &pb.TypedValue_StringVal{StringVal: "bar.com"}},
+			}}}},
+		}},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var pbPrefix, pbPath pb.Path
+			if err := proto.UnmarshalText(test.textPbPrefix, &pbPrefix); err != nil {
+				t.Fatalf("error in unmarshaling prefix: %v", err)
+			}
+			if err := proto.UnmarshalText(test.textPbPath, &pbPath); err != nil {
+				t.Fatalf("error in unmarshaling path: %v", err)
+			}
+			resp, err := s.Get(nil, &pb.GetRequest{
+				Prefix:   &pbPrefix,
+				Path:     []*pb.Path{&pbPath},
+				Encoding: pb.Encoding_PROTO,
+			})
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			got := resp.GetNotification()[0].GetUpdate()
+			if diff := cmp.Diff(test.wantUpdates, got, protocmp.Transform(), cmpopts.SortSlices(updateLess)); diff != "" {
+				t.Errorf("updates diff (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestGetWildcard(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-interfaces:interfaces": {
+			"interface": [
+				{
+					"name": "eth0",
+					"config": {"name": "eth0", "mtu": 1500, "description": "uplink", "enabled": true},
+					"state": {
+						"oper-status": "UP",
+						"counters": {"in-octets": "100"}
+					}
+				},
+				{
+					"name": "eth1",
+					"config": {"name": "eth1"},
+					"state": {"oper-status": "DOWN"}
+				}
+			]
+		}
+	}`
+
+	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+
+	operStatusUpdates := []*pb.Update{{
+		Path: mustPath("/interfaces/interface[name=eth0]/state/oper-status"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "UP"}},
+	}, {
+		Path: mustPath("/interfaces/interface[name=eth1]/state/oper-status"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "DOWN"}},
+	}}
+
+	tests := []struct {
+		desc        string
+		prefix      string
+		path        string
+		encoding    pb.Encoding
+		wantUpdates []*pb.Update
+	}{{
+		desc:        "key wildcard",
+		path:        "/interfaces/interface[name=*]/state/oper-status",
+		wantUpdates: operStatusUpdates,
+	}, {
+		desc:        "multi-level wildcard",
+		path:        "/.../oper-status",
+		wantUpdates: operStatusUpdates,
+	}, {
+		desc:        "wildcards in prefix",
+		prefix:      "/interfaces/interface[name=*]",
+		path:        "/state/oper-status",
+		wantUpdates: operStatusUpdates,
+	}, {
+		desc: "element name wildcard",
+		path: "/interfaces/interface[name=eth1]/*/name",
+		wantUpdates: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth1]/config/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth1"}},
+		}},
+	}, {
+		desc:     "containers",
+		path:     "/interfaces/interface[name=*]/state/counters",
+		encoding: pb.Encoding_PROTO,
+		wantUpdates: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth0]/state/counters/in-octets"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: 100}},
+		}},
+	}, {
+		desc:     "container with several leaves",
+		path:     "/interfaces/interface[name=eth0]/config",
+		encoding: pb.Encoding_PROTO,
+		wantUpdates: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth0]/config/description"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "uplink"}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth0]/config/enabled"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_BoolVal{BoolVal: true}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth0]/config/mtu"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: 1500}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth0]/config/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth0"}},
+		}},
+	}, {
+		desc:     "trailing multi-level wildcard",
+		path:     "/interfaces/interface[name=eth1]/...",
+		encoding: pb.Encoding_PROTO,
+		wantUpdates: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth1]/config/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth1"}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth1]/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth1"}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth1]/state/oper-status"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "DOWN"}},
+		}},
+	}, {
+		desc: "no match",
+		path: "/interfaces/interface[name=*]/state/mtu",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			req := &pb.GetRequest{
+				Path:     []*pb.Path{mustPath(test.path)},
+				Encoding: test.encoding,
+			}
+			if test.prefix != "" {
+				req.Prefix = mustPath(test.prefix)
+			}
+			resp, err := s.Get(nil, req)
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			n := resp.GetNotification()[0]
+			var got []*pb.Update
+			for _, u := range n.GetUpdate() {
+				got = append(got, &pb.Update{Path: gnmiFullPath(n.GetPrefix(), u.GetPath()), Val: u.GetVal()})
+			}
+			if diff := cmp.Diff(test.wantUpdates, got, protocmp.Transform(), cmpopts.SortSlices(updateLess), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("updates diff (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestGetDepth(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-interfaces:interfaces": {
+			"interface": [
+				{
+					"name": "eth0",
+					"config": {"name": "eth0"},
+					"state": {
+						"oper-status": "UP",
+						"counters": {"in-octets": "100"}
+					}
+				},
+				{
+					"name": "eth1",
+					"config": {"name": "eth1"}
+				}
+			]
+		}
+	}`
+
+	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	truncated := func(path string) *pb.Update {
+		return &pb.Update{
+			Path: mustPath(path),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte("{}")}},
+		}
+	}
+	depth := func(level uint32) []*extpb.Extension {
+		return []*extpb.Extension{{Ext: &extpb.Extension_Depth{Depth: &extpb.Depth{Level: level}}}}
+	}
+
+	t.Run("IETF JSON", func(t *testing.T) {
+		resp, err := s.Get(nil, &pb.GetRequest{
+			Path:      []*pb.Path{mustPath("/interfaces")},
+			Encoding:  pb.Encoding_JSON_IETF,
+			Extension: depth(2),
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		updates := resp.GetNotification()[0].GetUpdate()
+		var gotVal, wantVal interface{}
+		if err := json.Unmarshal(updates[0].GetVal().GetJsonIetfVal(), &gotVal); err != nil {
+			t.Fatalf("error in unmarshaling IETF JSON data to json container: %v", err)
+		}
+		if err := json.Unmarshal([]byte(`{"openconfig-interfaces:interface": [{"name": "eth0"}, {"name": "eth1"}]}`), &wantVal); err != nil {
+			t.Fatalf("error in unmarshaling IETF JSON data to json container: %v", err)
+		}
+		if diff := cmp.Diff(wantVal, gotVal); diff != "" {
+			t.Errorf("response value diff (-want +got):\n%v", diff)
+		}
+		wantTruncated := []*pb.Update{
+			truncated("/interfaces/interface[name=eth0]/config"),
+			truncated("/interfaces/interface[name=eth0]/state"),
+			truncated("/interfaces/interface[name=eth1]/config"),
+		}
+		if diff := cmp.Diff(wantTruncated, updates[1:], protocmp.Transform()); diff != "" {
+			t.Errorf("truncated subtrees diff (-want +got):\n%v", diff)
+		}
+	})
+
+	t.Run("PROTO", func(t *testing.T) {
+		resp, err := s.Get(nil, &pb.GetRequest{
+			Path:      []*pb.Path{mustPath("/interfaces/interface[name=eth0]")},
+			Encoding:  pb.Encoding_PROTO,
+			Extension: depth(2),
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		want := []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth0]/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth0"}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth0]/config/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth0"}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth0]/state/oper-status"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "UP"}},
+		},
+			truncated("/interfaces/interface[name=eth0]/state/counters"),
+		}
+		if diff := cmp.Diff(want, resp.GetNotification()[0].GetUpdate(), protocmp.Transform(), cmpopts.SortSlices(updateLess)); diff != "" {
+			t.Errorf("updates diff (-want +got):\n%v", diff)
+		}
+	})
+
+	t.Run("no depth limit", func(t *testing.T) {
+		resp, err := s.Get(nil, &pb.GetRequest{
+			Path:      []*pb.Path{mustPath("/interfaces/interface[name=eth0]/state")},
+			Encoding:  pb.Encoding_PROTO,
+			Extension: depth(0),
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if got := len(resp.GetNotification()[0].GetUpdate()); got != 2 {
+			t.Errorf("got %d updates, want 2", got)
+		}
+	})
+
+	subscribe := func(mode pb.SubscriptionList_Mode) (*fakeSubscribeServer, chan error) {
+		stream := newFakeSubscribeServer()
+		errC := make(chan error)
+		go func() {
+			errC <- s.Subscribe(stream)
+		}()
+		stream.reqC <- &pb.SubscribeRequest{
+			Request: &pb.SubscribeRequest_Subscribe{
+				Subscribe: &pb.SubscriptionList{
+					Mode:         mode,
+					Encoding:     pb.Encoding_JSON_IETF,
+					Subscription: []*pb.Subscription{{Path: mustPath("/interfaces/interface[name=eth0]")}},
+				},
+			},
+			Extension: depth(1),
+		}
+		return stream, errC
+	}
+
+	t.Run("ONCE subscription", func(t *testing.T) {
+		stream, errC := subscribe(pb.SubscriptionList_ONCE)
+		defer stream.cancel()
+		stream.checkResponses(t, []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth0]/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth0"}},
+		},
+			truncated("/interfaces/interface[name=eth0]/config"),
+			truncated("/interfaces/interface[name=eth0]/state"),
+		})
+		if err := <-errC; err != nil {
+			t.Errorf("got error %v, want nil", err)
+		}
+	})
+
+	t.Run("STREAM subscription", func(t *testing.T) {
+		stream, errC := subscribe(pb.SubscriptionList_STREAM)
+		defer stream.cancel()
+		if got := status.Code(<-errC); got != codes.InvalidArgument {
+			t.Errorf("got return code %v, want %v", got, codes.InvalidArgument)
+		}
+	})
+}
+
 // runTestGet requests a path from the server by Get grpc call, and compares if
 // the return code and response value are expected.
 func runTestGet(t *testing.T, s *Server, textPbPath string, wantRetCode codes.Code, wantRespVal interface{}, useModels []*pb.ModelData) {
@@ -880,6 +1511,23 @@ func TestReplace(t *testing.T) {
 				]
 			}
 		}`,
+	}, {
+		desc:       "replace a keyed list subtree with a conflicting key",
+		initConfig: `{}`,
+		op:         pb.UpdateResult_REPLACE,
+		textPbPath: `
+			elem: <name: "components" >
+			elem: <
+				name: "component"
+				key: <key: "name" value: "swpri1-1-1" >
+			>`,
+		val: &pb.TypedValue{
+			Value: &pb.TypedValue_JsonIetfVal{
+				JsonIetfVal: []byte(`{"name": "swpri1-1-2", "config": {"name": "swpri1-1-2"}}`),
+			},
+		},
+		wantRetCode: codes.InvalidArgument,
+		wantConfig:  `{}`,
 	}, {
 		desc: "replace node with int type attribute in its precedent path",
 		initConfig: `{
@@ -1167,218 +1815,1942 @@ func runTestSet(t *testing.T, m *Model, tc gnmiSetTestCase) {
 	}
 }
 
-func TestSubscribeOnce(t *testing.T) {
-	jsonConfigRoot := `{
+func TestSetTransaction(t *testing.T) {
+	initConfig := `{
 		"openconfig-system:system": {
-			"openconfig-openflow:openflow": {
-				"agent": {
-					"state": {
-						"failure-mode": "SECURE",
-						"max-backoff": 10
+			"config": {"hostname": "switch_a"}
+		}
+	}`
+	hostname := &pb.Update{
+		Path: mustPath("/system/config/hostname"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_b"}},
+	}
+	domainName := &pb.Update{
+		Path: mustPath("/system/config/domain-name"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "example.com"}},
+	}
+	unknownLeaf := &pb.Update{
+		Path: mustPath("/system/config/unknown"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "foo"}},
+	}
+
+	tests := []struct {
+		desc          string
+		config        string // startup config, initConfig if empty.
+		updates       []*pb.Update
+		applyErr      error
+		wantRetCode   codes.Code
+		wantCallbacks []string // hostname and domain name of the configs applied.
+		wantConfig    string
+	}{{
+		desc:          "all operations applied at once",
+		updates:       []*pb.Update{hostname, domainName},
+		wantRetCode:   codes.OK,
+		wantCallbacks: []string{"switch_b/example.com"},
+		wantConfig: `{
+			"openconfig-system:system": {
+				"config": {"hostname": "switch_b", "domain-name": "example.com"}
+			}
+		}`,
+	}, {
+		desc:        "invalid operation",
+		updates:     []*pb.Update{hostname, unknownLeaf},
+		wantRetCode: codes.NotFound,
+		wantConfig:  initConfig,
+	}, {
+		desc:          "device failure rolled back",
+		updates:       []*pb.Update{hostname, domainName},
+		applyErr:      errors.New("device failure"),
+		wantRetCode:   codes.Aborted,
+		wantCallbacks: []string{"switch_b/example.com", "switch_a/"},
+		wantConfig:    initConfig,
+	}, {
+		desc: "config with a list keyed by a union",
+		config: `{
+			"openconfig-system:system": {
+				"config": {"hostname": "switch_a"},
+				"cpus": {"cpu": [{"index": 0, "state": {"index": 0}}]}
+			}
+		}`,
+		updates:       []*pb.Update{hostname},
+		wantRetCode:   codes.OK,
+		wantCallbacks: []string{"switch_b/"},
+		wantConfig: `{
+			"openconfig-system:system": {
+				"config": {"hostname": "switch_b"},
+				"cpus": {"cpu": [{"index": 0, "state": {"index": 0}}]}
+			}
+		}`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var gotCallbacks []string
+			callback := func(config ygot.ValidatedGoStruct) error {
+				str := func(p *string) string {
+					if p == nil {
+						return ""
 					}
+					return *p
+				}
+				c := config.(*gostruct.Device).System.Config
+				gotCallbacks = append(gotCallbacks, str(c.Hostname)+"/"+str(c.DomainName))
+				if len(gotCallbacks) == 1 {
+					return test.applyErr
 				}
+				return nil
 			}
-		},
-	"openconfig-platform:components": {
-	    "component": [
-	      {
-	        "state": {
-			  "oper-status": "ACTIVE"
-	        },
-	        "name": "swpri1-1-1",
-			"config": {
-				"name": "swpri1-1-1"
+			config := initConfig
+			if test.config != "" {
+				config = test.config
 			}
-	      },
-		  {
-	        "state": {
-			  "oper-status": "INACTIVE"
-	        },
-	        "name": "swpri2-2-2",
-			"config": {
-				"name": "swpri2-2-2"
+			s, err := NewServer(model, []byte(config), nil)
+			if err != nil {
+				t.Fatalf("error in creating server: %v", err)
 			}
-	      }
-	    ]
+			s.callback = callback
+
+			_, err = s.Set(nil, &pb.SetRequest{Update: test.updates})
+			if got := status.Code(err); got != test.wantRetCode {
+				t.Fatalf("got return code %v, want %v: %v", got, test.wantRetCode, err)
+			}
+			if diff := cmp.Diff(test.wantCallbacks, gotCallbacks); diff != "" {
+				t.Errorf("applied configs diff (-want +got):\n%v", diff)
+			}
+
+			wantConfig, err := model.NewConfigStruct([]byte(test.wantConfig))
+			if err != nil {
+				t.Fatalf("wantConfig data cannot be loaded as a config struct: %v", err)
+			}
+			// The lists keyed by a union have pointer keys, so the configs are
+			// compared as JSON.
+			wantJSON, err := ygot.EmitJSON(wantConfig, &ygot.EmitJSONConfig{Format: ygot.RFC7951})
+			if err != nil {
+				t.Fatalf("error in emitting wantConfig JSON: %v", err)
+			}
+			gotJSON, err := ygot.EmitJSON(s.config, &ygot.EmitJSONConfig{Format: ygot.RFC7951})
+			if err != nil {
+				t.Fatalf("error in emitting server config JSON: %v", err)
+			}
+			if diff := cmp.Diff(wantJSON, gotJSON); diff != "" {
+				t.Errorf("server config diff (-want +got):\n%v", diff)
+			}
+		})
 	}
-}`
-	pathAgentState := &pb.Path{
-		Elem: []*pb.PathElem{
-			&pb.PathElem{Name: "system"},
-			&pb.PathElem{Name: "openflow"},
-			&pb.PathElem{Name: "agent"},
-			&pb.PathElem{Name: "state"},
-		}}
-	pathAgentFailureMode := proto.Clone(pathAgentState).(*pb.Path)
-	pathAgentFailureMode.Elem = append(pathAgentFailureMode.Elem, &pb.PathElem{Name: "failure-mode"})
-	pathAgentMaxBackoff := proto.Clone(pathAgentState).(*pb.Path)
-	pathAgentMaxBackoff.Elem = append(pathAgentMaxBackoff.Elem, &pb.PathElem{Name: "max-backoff"})
-	pathAgentFoo := proto.Clone(pathAgentState).(*pb.Path)
-	pathAgentFoo.Elem = append(pathAgentMaxBackoff.Elem, &pb.PathElem{Name: "foo"})
-	pathComponentSw1State := &pb.Path{
-		Elem: []*pb.PathElem{
-			&pb.PathElem{Name: "components"},
-			&pb.PathElem{Name: "component", Key: map[string]string{"name": "swpri1-1-1"}},
-			&pb.PathElem{Name: "state"},
-		}}
-	pathComponentSw2Oper := &pb.Path{
-		Elem: []*pb.PathElem{
-			&pb.PathElem{Name: "components"},
-			&pb.PathElem{Name: "component", Key: map[string]string{"name": "swpri2-2-2"}},
-			&pb.PathElem{Name: "state"},
-			&pb.PathElem{Name: "oper-status"},
-		}}
-	pathComponentSw3State := &pb.Path{
-		Elem: []*pb.PathElem{
-			&pb.PathElem{Name: "components"},
-			&pb.PathElem{Name: "component", Key: map[string]string{"name": "swpri3-3-3"}},
-			&pb.PathElem{Name: "state"},
-		}}
-	pathComponentStartOper := &pb.Path{
-		Elem: []*pb.PathElem{
-			&pb.PathElem{Name: "components"},
-			&pb.PathElem{Name: "component", Key: map[string]string{"name": "*"}},
-			&pb.PathElem{Name: "state"},
-			&pb.PathElem{Name: "oper-status"},
-		}}
-	pathComponentStartState := proto.Clone(pathComponentStartOper).(*pb.Path)
-	pathComponentStartState.Elem = pathComponentStartState.Elem[:len(pathComponentStartState.Elem)-1]
-	pathComponentSw1Oper := proto.Clone(pathComponentSw1State).(*pb.Path)
-	pathComponentSw1Oper.Elem = append(pathComponentSw1Oper.Elem, &pb.PathElem{Name: "oper-status"})
-	pathComponentSw1Star := proto.Clone(pathComponentSw1State).(*pb.Path)
-	pathComponentSw1Star.Elem[len(pathComponentSw1Star.Elem)-1].Name = "*"
+}
 
-	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
-	if err != nil {
-		t.Fatalf("error in creating server: %v", err)
+func TestSetLeafrefs(t *testing.T) {
+	initConfig := `{
+		"openconfig-interfaces:interfaces": {
+			"interface": [
+				{"name": "eth0", "config": {"name": "eth0"}},
+				{"name": "eth1", "config": {"name": "eth1"}}
+			]
+		},
+		"openconfig-system:system": {
+			"openconfig-openflow:openflow": {
+				"controllers": {
+					"controller": [{
+						"name": "main",
+						"config": {"name": "main"},
+						"connections": {
+							"connection": [{
+								"aux-id": 0,
+								"config": {"aux-id": 0, "source-interface": "eth0"}
+							}]
+						}
+					}]
+				}
+			}
+		}
+	}`
+	sourceInterface := func(name string) *pb.Update {
+		return &pb.Update{
+			Path: mustPath("/system/openflow/controllers/controller[name=main]/connections/connection[aux-id=0]/config/source-interface"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: name}},
+		}
 	}
 
 	tests := []struct {
-		desc              string
-		subscriptions     []*pb.Subscription
-		pathPrefix        *pb.Path
-		updatesOnly       bool
-		wantError         error
-		wantNotifications []*pb.Notification
+		desc        string
+		req         *pb.SetRequest
+		wantRetCode codes.Code
 	}{{
-		desc: "Subscribe to leaf node",
-		subscriptions: []*pb.Subscription{
-			&pb.Subscription{
-				Path: pathAgentFailureMode}},
-		wantNotifications: []*pb.Notification{
-			&pb.Notification{
-				Update: []*pb.Update{
-					&pb.Update{
-						Path: pathAgentFailureMode,
-						Val: &pb.TypedValue{
-							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}}}}},
-	}, {
-		desc: "Subscribe to multiple leaf nodes",
-		subscriptions: []*pb.Subscription{
-			&pb.Subscription{
-				Path: pathAgentFailureMode},
-			&pb.Subscription{
-				Path: pathAgentMaxBackoff}},
-		wantNotifications: []*pb.Notification{
-			&pb.Notification{
-				Update: []*pb.Update{
-					&pb.Update{
-						Path: pathAgentFailureMode,
-						Val: &pb.TypedValue{
-							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}}}},
-			&pb.Notification{
-				Update: []*pb.Update{
-					&pb.Update{
-						Path: pathAgentMaxBackoff,
-						Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(10)}}}}}},
+		desc:        "leafref to an existing leaf",
+		req:         &pb.SetRequest{Update: []*pb.Update{sourceInterface("eth1")}},
+		wantRetCode: codes.OK,
 	}, {
-		desc: "Subscribe to container node",
-		subscriptions: []*pb.Subscription{
-			&pb.Subscription{
-				Path: pathAgentState}},
-		wantNotifications: []*pb.Notification{
-			&pb.Notification{
-				Update: []*pb.Update{
-					&pb.Update{
-						Path: pathAgentFailureMode,
-						Val: &pb.TypedValue{
-							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
-					&pb.Update{
-						Path: pathAgentMaxBackoff,
-						Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(10)}}}}}},
+		desc:        "leafref to a missing leaf",
+		req:         &pb.SetRequest{Update: []*pb.Update{sourceInterface("eth9")}},
+		wantRetCode: codes.InvalidArgument,
 	}, {
-		desc: "Subscribe to container and leaf nodes",
-		subscriptions: []*pb.Subscription{
-			&pb.Subscription{
-				Path: pathAgentState},
-			&pb.Subscription{
-				Path: pathAgentFailureMode},
-		},
-		wantNotifications: []*pb.Notification{
-			&pb.Notification{
-				Update: []*pb.Update{
-					&pb.Update{
-						Path: pathAgentFailureMode,
-						Val: &pb.TypedValue{
-							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
-					&pb.Update{
-						Path: pathAgentMaxBackoff,
-						Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(10)}}}}},
-			&pb.Notification{
-				Update: []*pb.Update{
-					&pb.Update{
-						Path: pathAgentFailureMode,
-						Val: &pb.TypedValue{
-							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}}}}},
+		desc: "leafref to a leaf added by the same request",
+		req: &pb.SetRequest{Update: []*pb.Update{sourceInterface("eth2"), {
+			Path: mustPath("/interfaces/interface[name=eth2]/config/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth2"}},
+		}}},
+		wantRetCode: codes.OK,
 	}, {
-		desc:       "Subscribe with prefix",
-		pathPrefix: pathAgentState,
-		subscriptions: []*pb.Subscription{
-			&pb.Subscription{
-				Path: &pb.Path{Elem: []*pb.PathElem{&pb.PathElem{Name: "failure-mode"}}}}},
-		wantNotifications: []*pb.Notification{
-			&pb.Notification{
-				Update: []*pb.Update{
-					&pb.Update{
-						Path: pathAgentFailureMode,
-						Val: &pb.TypedValue{
-							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}}}}},
+		desc: "list key not matching its config leaf",
+		req: &pb.SetRequest{Update: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth2]/config/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth3"}},
+		}}},
+		wantRetCode: codes.InvalidArgument,
 	}, {
-		desc: "Subscribe to keyed path",
-		subscriptions: []*pb.Subscription{
-			&pb.Subscription{
-				Path: pathComponentSw1Oper}},
-		wantNotifications: []*pb.Notification{
-			&pb.Notification{
-				Update: []*pb.Update{
-					&pb.Update{
-						Path: pathComponentSw1Oper,
-						Val: &pb.TypedValue{
-							Value: &pb.TypedValue_StringVal{StringVal: "ACTIVE"}}}}}},
+		desc:        "delete a referenced leaf",
+		req:         &pb.SetRequest{Delete: []*pb.Path{mustPath("/interfaces/interface[name=eth0]")}},
+		wantRetCode: codes.InvalidArgument,
 	}, {
-		desc: "Subscribe to leaf node with wildcard key",
-		subscriptions: []*pb.Subscription{
-			&pb.Subscription{
-				Path: pathComponentStartOper}},
-		wantNotifications: []*pb.Notification{
-			&pb.Notification{
-				Update: []*pb.Update{
-					&pb.Update{
-						Path: pathComponentSw1Oper,
-						Val: &pb.TypedValue{
-							Value: &pb.TypedValue_StringVal{StringVal: "ACTIVE"}}},
-					&pb.Update{
-						Path: pathComponentSw2Oper,
-						Val: &pb.TypedValue{
-							Value: &pb.TypedValue_StringVal{StringVal: "INACTIVE"}}}}}},
+		desc:        "delete an unreferenced leaf",
+		req:         &pb.SetRequest{Delete: []*pb.Path{mustPath("/interfaces/interface[name=eth1]")}},
+		wantRetCode: codes.OK,
 	}, {
-		desc: "Subscribe to container node with wildcard key",
-		subscriptions: []*pb.Subscription{
-			&pb.Subscription{
+		desc: "delete a referenced leaf and its reference",
+		req: &pb.SetRequest{Delete: []*pb.Path{
+			mustPath("/interfaces/interface[name=eth0]"),
+			mustPath("/system/openflow/controllers/controller[name=main]/connections/connection[aux-id=0]/config/source-interface"),
+		}},
+		wantRetCode: codes.OK,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			s, err := NewServer(model, []byte(initConfig), nil)
+			if err != nil {
+				t.Fatalf("error in creating server: %v", err)
+			}
+			_, err = s.Set(nil, test.req)
+			if got := status.Code(err); got != test.wantRetCode {
+				t.Fatalf("got return code %v, want %v: %v", got, test.wantRetCode, err)
+			}
+			if err := s.config.Validate(); err != nil {
+				t.Errorf("server config is invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestSetDiffCallback(t *testing.T) {
+	initConfig := `{
+		"openconfig-system:system": {
+			"config": {"hostname": "switch_a", "domain-name": "example.com"}
+		}
+	}`
+	var gotOld, gotNew ygot.ValidatedGoStruct
+	var gotDiff []*pb.Notification
+	callback := func(oldConfig, newConfig ygot.ValidatedGoStruct, diff []*pb.Notification) error {
+		gotOld, gotNew, gotDiff = oldConfig, newConfig, diff
+		return nil
+	}
+	s, err := NewServer(model, []byte(initConfig), nil, WithConfigDiffCallback(callback))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	initStruct := s.config
+
+	_, err = s.Set(nil, &pb.SetRequest{
+		Delete: []*pb.Path{mustPath("/system/config/domain-name")},
+		Update: []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_b"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+	if gotOld != initStruct {
+		t.Errorf("got old config %v, want the initial config %v", gotOld, initStruct)
+	}
+	if gotNew != s.config {
+		t.Errorf("got new config %v, want the server config %v", gotNew, s.config)
+	}
+	wantDiff := []*pb.Notification{{
+		Update: []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_b"}},
+		}},
+		Delete: []*pb.Path{mustPath("/system/config/domain-name")},
+	}}
+	if diff := cmp.Diff(wantDiff, gotDiff, protocmp.Transform(),
+		protocmp.IgnoreFields(&pb.Notification{}, "timestamp")); diff != "" {
+		t.Errorf("config diff (-want +got):\n%v", diff)
+	}
+}
+
+// cliHandler serves a "cli" origin whose ASCII config holds "hostname" and
+// "domain-name" lines.
+type cliHandler struct{}
+
+func (cliHandler) Get(config ygot.ValidatedGoStruct, path *pb.Path) (*pb.TypedValue, error) {
+	var lines []string
+	if c := config.(*gostruct.Device).System; c != nil && c.Config != nil {
+		if c.Config.Hostname != nil {
+			lines = append(lines, "hostname "+*c.Config.Hostname)
+		}
+		if c.Config.DomainName != nil {
+			lines = append(lines, "domain-name "+*c.Config.DomainName)
+		}
+	}
+	return &pb.TypedValue{Value: &pb.TypedValue_AsciiVal{AsciiVal: strings.Join(lines, "\n")}}, nil
+}
+
+func (cliHandler) Set(config ygot.ValidatedGoStruct, op pb.UpdateResult_Operation, path *pb.Path, val *pb.TypedValue) error {
+	device := config.(*gostruct.Device)
+	if device.System == nil {
+		device.System = &gostruct.OpenconfigSystem_System{}
+	}
+	if device.System.Config == nil {
+		device.System.Config = &gostruct.OpenconfigSystem_System_Config{}
+	}
+	c := device.System.Config
+	if op != pb.UpdateResult_UPDATE {
+		c.Hostname, c.DomainName = nil, nil
+	}
+	for _, line := range strings.Split(val.GetAsciiVal(), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case len(fields) == 2 && fields[0] == "hostname":
+			c.Hostname = ygot.String(fields[1])
+		case len(fields) == 2 && fields[0] == "domain-name":
+			c.DomainName = ygot.String(fields[1])
+		default:
+			return fmt.Errorf("invalid command: %q", line)
+		}
+	}
+	return nil
+}
+
+func TestOriginHandler(t *testing.T) {
+	initConfig := `{
+		"openconfig-system:system": {
+			"config": {"hostname": "switch_a", "domain-name": "example.com"}
+		}
+	}`
+	cliPrefix := &pb.Path{Origin: "cli"}
+	asciiVal := func(s string) *pb.TypedValue {
+		return &pb.TypedValue{Value: &pb.TypedValue_AsciiVal{AsciiVal: s}}
+	}
+
+	tests := []struct {
+		desc        string
+		req         *pb.SetRequest
+		wantRetCode codes.Code
+		wantConfig  string
+		wantCLI     string
+	}{{
+		desc: "update cli origin",
+		req: &pb.SetRequest{
+			Prefix: cliPrefix,
+			Update: []*pb.Update{{Path: &pb.Path{}, Val: asciiVal("hostname switch_b")}},
+		},
+		wantConfig: `{
+			"openconfig-system:system": {
+				"config": {"hostname": "switch_b", "domain-name": "example.com"}
+			}
+		}`,
+		wantCLI: "hostname switch_b\ndomain-name example.com",
+	}, {
+		desc: "invalid cli config",
+		req: &pb.SetRequest{
+			Prefix: cliPrefix,
+			Update: []*pb.Update{{Path: &pb.Path{}, Val: asciiVal("shutdown")}},
+		},
+		wantRetCode: codes.InvalidArgument,
+		wantConfig:  initConfig,
+		wantCLI:     "hostname switch_a\ndomain-name example.com",
+	}, {
+		desc: "union_replace of openconfig and cli origins",
+		req: &pb.SetRequest{
+			UnionReplace: []*pb.Update{{
+				Path: &pb.Path{Origin: OpenConfigOrigin},
+				Val: &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{
+					"openconfig-system:system": {
+						"config": {"login-banner": "welcome"}
+					}
+				}`)}},
+			}, {
+				Path: &pb.Path{Origin: "cli"},
+				Val:  asciiVal("hostname switch_c"),
+			}},
+		},
+		wantConfig: `{
+			"openconfig-system:system": {
+				"config": {"hostname": "switch_c", "login-banner": "welcome"}
+			}
+		}`,
+		wantCLI: "hostname switch_c",
+	}, {
+		desc: "union_replace of openconfig subtrees",
+		req: &pb.SetRequest{
+			UnionReplace: []*pb.Update{{
+				Path: mustPath("/system/config"),
+				Val: &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{
+					"hostname": "switch_d"
+				}`)}},
+			}, {
+				Path: mustPath("/system/config/login-banner"),
+				Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "welcome"}},
+			}, {
+				Path: mustPath("/interfaces/interface[name=eth0]/config/name"),
+				Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth0"}},
+			}},
+		},
+		wantConfig: `{
+			"openconfig-system:system": {
+				"config": {"hostname": "switch_d", "login-banner": "welcome"}
+			},
+			"openconfig-interfaces:interfaces": {
+				"interface": [{"name": "eth0", "config": {"name": "eth0"}}]
+			}
+		}`,
+		wantCLI: "hostname switch_d",
+	}, {
+		desc: "unsupported origin",
+		req: &pb.SetRequest{
+			Prefix: &pb.Path{Origin: "srl"},
+			Update: []*pb.Update{{Path: &pb.Path{}, Val: asciiVal("hostname switch_b")}},
+		},
+		wantRetCode: codes.Unimplemented,
+		wantConfig:  initConfig,
+		wantCLI:     "hostname switch_a\ndomain-name example.com",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			s, err := NewServer(model, []byte(initConfig), nil, WithOriginHandler("cli", cliHandler{}))
+			if err != nil {
+				t.Fatalf("error in creating server: %v", err)
+			}
+			_, err = s.Set(nil, test.req)
+			if got := status.Code(err); got != test.wantRetCode {
+				t.Fatalf("got return code %v, want %v: %v", got, test.wantRetCode, err)
+			}
+
+			wantConfig, err := model.NewConfigStruct([]byte(test.wantConfig))
+			if err != nil {
+				t.Fatalf("wantConfig data cannot be loaded as a config struct: %v", err)
+			}
+			if diff := cmp.Diff(wantConfig, s.config); diff != "" {
+				t.Errorf("server config diff (-want +got):\n%v", diff)
+			}
+
+			resp, err := s.Get(nil, &pb.GetRequest{
+				Prefix:   cliPrefix,
+				Path:     []*pb.Path{{}},
+				Encoding: pb.Encoding_ASCII,
+			})
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			if got := resp.GetNotification()[0].GetUpdate()[0].GetVal().GetAsciiVal(); got != test.wantCLI {
+				t.Errorf("got cli config %q, want %q", got, test.wantCLI)
+			}
+		})
+	}
+}
+
+func TestSetCommitConfirmed(t *testing.T) {
+	initConfig := `{
+		"openconfig-system:system": {
+			"config": {"hostname": "switch_a"}
+		}
+	}`
+	setHostname := &pb.SetRequest{
+		Update: []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_b"}},
+		}},
+	}
+	commitReq := func(req *pb.SetRequest, commit *extpb.Commit) *pb.SetRequest {
+		req = proto.Clone(req).(*pb.SetRequest)
+		req.Extension = []*extpb.Extension{{Ext: &extpb.Extension_Commit{Commit: commit}}}
+		return req
+	}
+	commit := func(id string, d time.Duration) *pb.SetRequest {
+		return commitReq(setHostname, &extpb.Commit{
+			Id:     id,
+			Action: &extpb.Commit_Commit{Commit: &extpb.CommitRequest{RollbackDuration: durationpb.New(d)}},
+		})
+	}
+	confirm := func(id string) *pb.SetRequest {
+		return commitReq(&pb.SetRequest{}, &extpb.Commit{Id: id, Action: &extpb.Commit_Confirm{Confirm: &extpb.CommitConfirm{}}})
+	}
+	cancel := func(id string) *pb.SetRequest {
+		return commitReq(&pb.SetRequest{}, &extpb.Commit{Id: id, Action: &extpb.Commit_Cancel{Cancel: &extpb.CommitCancel{}}})
+	}
+	extend := func(id string, d time.Duration) *pb.SetRequest {
+		return commitReq(&pb.SetRequest{}, &extpb.Commit{
+			Id: id,
+			Action: &extpb.Commit_SetRollbackDuration{SetRollbackDuration: &extpb.CommitSetRollbackDuration{
+				RollbackDuration: durationpb.New(d),
+			}},
+		})
+	}
+
+	tests := []struct {
+		desc         string
+		reqs         []*pb.SetRequest
+		wantRetCodes []codes.Code
+		wait         time.Duration
+		wantHostname string
+		wantApplied  []string // hostnames applied through the callback.
+	}{{
+		desc:         "confirmed commit",
+		reqs:         []*pb.SetRequest{commit("c1", 100*time.Millisecond), confirm("c1")},
+		wantRetCodes: []codes.Code{codes.OK, codes.OK},
+		wait:         200 * time.Millisecond,
+		wantHostname: "switch_b",
+		wantApplied:  []string{"switch_b"},
+	}, {
+		desc:         "rollback timer expired",
+		reqs:         []*pb.SetRequest{commit("c1", 100*time.Millisecond)},
+		wantRetCodes: []codes.Code{codes.OK},
+		wait:         200 * time.Millisecond,
+		wantHostname: "switch_a",
+		wantApplied:  []string{"switch_b", "switch_a"},
+	}, {
+		desc:         "cancelled commit",
+		reqs:         []*pb.SetRequest{commit("c1", time.Minute), cancel("c1")},
+		wantRetCodes: []codes.Code{codes.OK, codes.OK},
+		wantHostname: "switch_a",
+		wantApplied:  []string{"switch_b", "switch_a"},
+	}, {
+		desc:         "rollback duration extended",
+		reqs:         []*pb.SetRequest{commit("c1", 100*time.Millisecond), extend("c1", time.Minute)},
+		wantRetCodes: []codes.Code{codes.OK, codes.OK},
+		wait:         200 * time.Millisecond,
+		wantHostname: "switch_b",
+		wantApplied:  []string{"switch_b"},
+	}, {
+		desc:         "set while waiting for confirmation",
+		reqs:         []*pb.SetRequest{commit("c1", time.Minute), setHostname, commit("c2", time.Minute)},
+		wantRetCodes: []codes.Code{codes.OK, codes.FailedPrecondition, codes.FailedPrecondition},
+		wantHostname: "switch_b",
+		wantApplied:  []string{"switch_b"},
+	}, {
+		desc:         "confirm of another commit",
+		reqs:         []*pb.SetRequest{commit("c1", time.Minute), confirm("c2")},
+		wantRetCodes: []codes.Code{codes.OK, codes.InvalidArgument},
+		wantHostname: "switch_b",
+		wantApplied:  []string{"switch_b"},
+	}, {
+		desc:         "confirm without commit",
+		reqs:         []*pb.SetRequest{confirm("c1")},
+		wantRetCodes: []codes.Code{codes.FailedPrecondition},
+		wantHostname: "switch_a",
+	}, {
+		desc:         "confirm with operations",
+		reqs:         []*pb.SetRequest{commit("c1", time.Minute), commitReq(setHostname, confirm("c1").GetExtension()[0].GetCommit())},
+		wantRetCodes: []codes.Code{codes.OK, codes.InvalidArgument},
+		wantHostname: "switch_b",
+		wantApplied:  []string{"switch_b"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			s, err := NewServer(model, []byte(initConfig), nil)
+			if err != nil {
+				t.Fatalf("error in creating server: %v", err)
+			}
+			var mu sync.Mutex
+			var gotApplied []string
+			s.callback = func(config ygot.ValidatedGoStruct) error {
+				mu.Lock()
+				defer mu.Unlock()
+				gotApplied = append(gotApplied, *config.(*gostruct.Device).System.Config.Hostname)
+				return nil
+			}
+
+			for i, req := range test.reqs {
+				_, err := s.Set(nil, req)
+				if got := status.Code(err); got != test.wantRetCodes[i] {
+					t.Fatalf("request %d: got return code %v, want %v: %v", i, got, test.wantRetCodes[i], err)
+				}
+			}
+			time.Sleep(test.wait)
+
+			s.mu.RLock()
+			gotHostname := *s.config.(*gostruct.Device).System.Config.Hostname
+			s.mu.RUnlock()
+			if gotHostname != test.wantHostname {
+				t.Errorf("got hostname %q, want %q", gotHostname, test.wantHostname)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if diff := cmp.Diff(test.wantApplied, gotApplied); diff != "" {
+				t.Errorf("applied configs diff (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestSetMasterArbitration(t *testing.T) {
+	s, err := NewServer(model, nil, nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	set := func(role string, high, low uint64) *pb.SetRequest {
+		return &pb.SetRequest{
+			Update: []*pb.Update{{
+				Path: mustPath("/system/config/hostname"),
+				Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch"}},
+			}},
+			Extension: []*extpb.Extension{{
+				Ext: &extpb.Extension_MasterArbitration{MasterArbitration: &extpb.MasterArbitration{
+					Role:       &extpb.Role{Id: role},
+					ElectionId: &extpb.Uint128{High: high, Low: low},
+				}},
+			}},
+		}
+	}
+
+	steps := []struct {
+		desc        string
+		req         *pb.SetRequest
+		wantRetCode codes.Code
+	}{
+		{"first primary", set("ctrl", 0, 5), codes.OK},
+		{"same primary", set("ctrl", 0, 5), codes.OK},
+		{"lower election ID", set("ctrl", 0, 4), codes.PermissionDenied},
+		{"failover to higher election ID", set("ctrl", 1, 0), codes.OK},
+		{"former primary", set("ctrl", 0, 5), codes.PermissionDenied},
+		{"another role", set("backup", 0, 1), codes.OK},
+		{"no arbitration", &pb.SetRequest{}, codes.OK},
+		{"default role", set("", 0, 2), codes.OK},
+		{"no arbitration after default role election", &pb.SetRequest{}, codes.PermissionDenied},
+	}
+	for _, step := range steps {
+		_, err := s.Set(nil, step.req)
+		if got := status.Code(err); got != step.wantRetCode {
+			t.Fatalf("%s: got return code %v, want %v: %v", step.desc, got, step.wantRetCode, err)
+		}
+	}
+
+	tests := []struct {
+		desc        string
+		path        string
+		wantRetCode codes.Code
+		wantVal     string
+	}{{
+		desc:    "primary of a role",
+		path:    "/master-arbitration/role[id=ctrl]",
+		wantVal: `{"id": "ctrl", "election-id": {"high": "1", "low": "0"}}`,
+	}, {
+		desc: "primaries of all roles",
+		path: "/master-arbitration",
+		wantVal: `{"role": [
+			{"id": "", "election-id": {"high": "0", "low": "2"}},
+			{"id": "backup", "election-id": {"high": "0", "low": "1"}},
+			{"id": "ctrl", "election-id": {"high": "1", "low": "0"}}
+		]}`,
+	}, {
+		desc:        "unknown role",
+		path:        "/master-arbitration/role[id=foo]",
+		wantRetCode: codes.NotFound,
+	}}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp, err := s.Get(nil, &pb.GetRequest{
+				Prefix: &pb.Path{Origin: DiagnosticsOrigin},
+				Path:   []*pb.Path{mustPath(test.path)},
+			})
+			if got := status.Code(err); got != test.wantRetCode {
+				t.Fatalf("got return code %v, want %v: %v", got, test.wantRetCode, err)
+			}
+			if err != nil {
+				return
+			}
+			var gotVal, wantVal interface{}
+			if err := json.Unmarshal(resp.GetNotification()[0].GetUpdate()[0].GetVal().GetJsonIetfVal(), &gotVal); err != nil {
+				t.Fatalf("error in unmarshaling IETF JSON data to json container: %v", err)
+			}
+			if err := json.Unmarshal([]byte(test.wantVal), &wantVal); err != nil {
+				t.Fatalf("error in unmarshaling IETF JSON data to json container: %v", err)
+			}
+			if diff := cmp.Diff(wantVal, gotVal); diff != "" {
+				t.Errorf("response value diff (-want +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s, err := NewServer(model, nil, nil, WithHistorySize(100))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	hostnameUpdate := func(name string) *pb.Update {
+		return &pb.Update{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: name}},
+		}
+	}
+	// times[i] is a time at which the i-th hostname is committed.
+	hostnames := []string{"a", "b", "c"}
+	var times []int64
+	for _, name := range hostnames {
+		time.Sleep(10 * time.Millisecond)
+		if _, err := s.Set(nil, &pb.SetRequest{Update: []*pb.Update{hostnameUpdate(name)}}); err != nil {
+			t.Fatalf("error in setting hostname %q: %v", name, err)
+		}
+		times = append(times, time.Now().UnixNano())
+	}
+	snapshot := func(ts int64) []*extpb.Extension {
+		return []*extpb.Extension{{Ext: &extpb.Extension_History{History: &extpb.History{
+			Request: &extpb.History_SnapshotTime{SnapshotTime: ts},
+		}}}}
+	}
+	timeRange := func(start, end int64) []*extpb.Extension {
+		return []*extpb.Extension{{Ext: &extpb.Extension_History{History: &extpb.History{
+			Request: &extpb.History_Range{Range: &extpb.TimeRange{Start: start, End: end}},
+		}}}}
+	}
+
+	getTests := []struct {
+		desc        string
+		ext         []*extpb.Extension
+		wantRetCode codes.Code
+		wantVal     string
+	}{
+		{desc: "current config", wantVal: "c"},
+		{desc: "snapshot of first revision", ext: snapshot(times[0]), wantVal: "a"},
+		{desc: "snapshot of second revision", ext: snapshot(times[1]), wantVal: "b"},
+		{desc: "snapshot before history", ext: snapshot(1), wantRetCode: codes.OutOfRange},
+		{desc: "time range", ext: timeRange(times[0], times[2]), wantRetCode: codes.InvalidArgument},
+		{desc: "time range ending before its start", ext: timeRange(times[2], times[0]), wantRetCode: codes.InvalidArgument},
+	}
+	for _, test := range getTests {
+		t.Run(test.desc, func(t *testing.T) {
+			resp, err := s.Get(nil, &pb.GetRequest{
+				Path:      []*pb.Path{mustPath("/system/config/hostname")},
+				Encoding:  pb.Encoding_JSON_IETF,
+				Extension: test.ext,
+			})
+			if got := status.Code(err); got != test.wantRetCode {
+				t.Fatalf("got return code %v, want %v: %v", got, test.wantRetCode, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := resp.GetNotification()[0].GetUpdate()[0].GetVal().GetStringVal(); got != test.wantVal {
+				t.Errorf("got hostname %q, want %q", got, test.wantVal)
+			}
+		})
+	}
+
+	subscribeTests := []struct {
+		desc        string
+		mode        pb.SubscriptionList_Mode
+		ext         []*extpb.Extension
+		wantRetCode codes.Code
+		wantVals    []string
+	}{
+		{desc: "once snapshot", mode: pb.SubscriptionList_ONCE, ext: snapshot(times[1]), wantVals: []string{"b"}},
+		{desc: "once time range", mode: pb.SubscriptionList_ONCE, ext: timeRange(times[0], times[2]), wantVals: []string{"a", "b", "c"}},
+		{desc: "stream time range", mode: pb.SubscriptionList_STREAM, ext: timeRange(times[1], times[2]), wantVals: []string{"b", "c"}},
+		{desc: "stream snapshot", mode: pb.SubscriptionList_STREAM, ext: snapshot(times[1]), wantRetCode: codes.InvalidArgument},
+		{desc: "poll time range", mode: pb.SubscriptionList_POLL, ext: timeRange(times[0], times[2]), wantRetCode: codes.InvalidArgument},
+		{desc: "time range before history", mode: pb.SubscriptionList_ONCE, ext: timeRange(1, times[2]), wantRetCode: codes.OutOfRange},
+	}
+	for _, test := range subscribeTests {
+		t.Run(test.desc, func(t *testing.T) {
+			stream := newFakeSubscribeServer()
+			defer stream.cancel()
+			errC := make(chan error)
+			go func() {
+				errC <- s.Subscribe(stream)
+			}()
+			stream.reqC <- &pb.SubscribeRequest{
+				Request: &pb.SubscribeRequest_Subscribe{
+					Subscribe: &pb.SubscriptionList{
+						Mode:         test.mode,
+						Subscription: []*pb.Subscription{{Path: mustPath("/system/config/hostname")}},
+					},
+				},
+				Extension: test.ext,
+			}
+			var err error
+			select {
+			case err = <-errC:
+			case <-time.After(time.Second):
+				t.Fatalf("timeout waiting for the end of the subscription")
+			}
+			if got := status.Code(err); got != test.wantRetCode {
+				t.Fatalf("got return code %v, want %v: %v", got, test.wantRetCode, err)
+			}
+			if err != nil {
+				return
+			}
+
+			var gotVals []string
+			var gotSync bool
+			for len(stream.respC) > 0 {
+				resp := <-stream.respC
+				if resp.GetSyncResponse() {
+					gotSync = true
+					continue
+				}
+				for _, u := range resp.GetUpdate().GetUpdate() {
+					gotVals = append(gotVals, u.GetVal().GetStringVal())
+				}
+			}
+			if diff := cmp.Diff(test.wantVals, gotVals); diff != "" {
+				t.Errorf("hostnames diff (-want +got):\n%v", diff)
+			}
+			if !gotSync {
+				t.Errorf("did not receive sync_response message")
+			}
+		})
+	}
+
+	t.Run("history size", func(t *testing.T) {
+		s, err := NewServer(model, nil, nil, WithHistorySize(1))
+		if err != nil {
+			t.Fatalf("error in creating server: %v", err)
+		}
+		before := time.Now().UnixNano()
+		time.Sleep(10 * time.Millisecond)
+		if _, err := s.Set(nil, &pb.SetRequest{Update: []*pb.Update{hostnameUpdate("a")}}); err != nil {
+			t.Fatalf("error in setting hostname: %v", err)
+		}
+		_, err = s.Get(nil, &pb.GetRequest{
+			Path:      []*pb.Path{mustPath("/system/config/hostname")},
+			Extension: snapshot(before),
+		})
+		if got := status.Code(err); got != codes.OutOfRange {
+			t.Errorf("got return code %v, want %v: %v", got, codes.OutOfRange, err)
+		}
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "running-config.json")
+	startup := []byte(`{"openconfig-system:system": {"config": {"hostname": "startup"}}}`)
+	newServer := func() *Server {
+		t.Helper()
+		s, err := NewServer(model, startup, nil, WithPersistence(path))
+		if err != nil {
+			t.Fatalf("error in creating server: %v", err)
+		}
+		return s
+	}
+	hostname := func(s *Server) string {
+		if c := s.config.(*gostruct.Device).System; c != nil && c.Config != nil && c.Config.Hostname != nil {
+			return *c.Config.Hostname
+		}
+		return ""
+	}
+
+	s := newServer()
+	if got := hostname(s); got != "startup" {
+		t.Errorf("got hostname %q at first boot, want %q", got, "startup")
+	}
+	for _, name := range []string{"a", "b"} {
+		_, err := s.Set(nil, &pb.SetRequest{Update: []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: name}},
+		}}})
+		if err != nil {
+			t.Fatalf("error in setting hostname %q: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path + backupSuffix); err != nil {
+		t.Errorf("backup of the running config is missing: %v", err)
+	}
+
+	if got := hostname(newServer()); got != "b" {
+		t.Errorf("got hostname %q after restart, want %q", got, "b")
+	}
+	if err := os.WriteFile(path, []byte(`{"openconfig-system:system": {"config": {"hostname": 1}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := hostname(newServer()); got != "a" {
+		t.Errorf("got hostname %q with invalid running config, want %q from the backup", got, "a")
+	}
+	if err := os.WriteFile(path+backupSuffix, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := hostname(newServer()); got != "startup" {
+		t.Errorf("got hostname %q with invalid running config and backup, want %q", got, "startup")
+	}
+
+	// A commit waiting for confirmation is only persisted once confirmed.
+	path = filepath.Join(t.TempDir(), "running-config.json")
+	s = newServer()
+	commitExt := func(commit *extpb.Commit) []*extpb.Extension {
+		return []*extpb.Extension{{Ext: &extpb.Extension_Commit{Commit: commit}}}
+	}
+	_, err := s.Set(nil, &pb.SetRequest{
+		Update: []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "c"}},
+		}},
+		Extension: commitExt(&extpb.Commit{
+			Id:     "commit-1",
+			Action: &extpb.Commit_Commit{Commit: &extpb.CommitRequest{RollbackDuration: durationpb.New(time.Minute)}},
+		}),
+	})
+	if err != nil {
+		t.Fatalf("error in setting hostname with commit confirmed: %v", err)
+	}
+	if got := hostname(newServer()); got != "startup" {
+		t.Errorf("got hostname %q after restart with a commit waiting for confirmation, want %q", got, "startup")
+	}
+	if _, err := s.Set(nil, &pb.SetRequest{Extension: commitExt(&extpb.Commit{
+		Id:     "commit-1",
+		Action: &extpb.Commit_Confirm{Confirm: &extpb.CommitConfirm{}},
+	})}); err != nil {
+		t.Fatalf("error in confirming commit: %v", err)
+	}
+	if got := hostname(newServer()); got != "c" {
+		t.Errorf("got hostname %q after restart with the commit confirmed, want %q", got, "c")
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	s, err := NewServer(model, []byte(`{"openconfig-system:system": {"config": {"hostname": "startup"}}}`), nil, WithHistorySize(100))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	setHostname := func(ctx context.Context, name string) {
+		t.Helper()
+		_, err := s.Set(ctx, &pb.SetRequest{Update: []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: name}},
+		}}})
+		if err != nil {
+			t.Fatalf("error in setting hostname %q: %v", name, err)
+		}
+	}
+	hostnameUpdate := func(name string) *pb.Update {
+		return &pb.Update{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: name}},
+		}
+	}
+	setHostname(NewUserContext(context.Background(), "alice"), "a")
+	setHostname(NewUserContext(context.Background(), "bob"), "b")
+
+	type rev struct {
+		id      uint64
+		user    string
+		updates []*pb.Update
+	}
+	checkRevisions := func(want []rev) {
+		t.Helper()
+		var got []rev
+		for _, r := range s.Revisions(context.Background()) {
+			got = append(got, rev{id: r.ID, user: r.User, updates: r.Diff.GetUpdate()})
+		}
+		if diff := cmp.Diff(want, got, cmp.AllowUnexported(rev{}), protocmp.Transform()); diff != "" {
+			t.Errorf("revisions diff (-want +got):\n%v", diff)
+		}
+	}
+	checkRevisions([]rev{
+		{id: 1, updates: []*pb.Update{hostnameUpdate("startup")}},
+		{id: 2, user: "alice", updates: []*pb.Update{hostnameUpdate("a")}},
+		{id: 3, user: "bob", updates: []*pb.Update{hostnameUpdate("b")}},
+	})
+
+	diff, err := s.RevisionDiff(context.Background(), 3, 1)
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+	if diff := cmp.Diff([]*pb.Update{hostnameUpdate("startup")}, diff.GetUpdate(), protocmp.Transform()); diff != "" {
+		t.Errorf("diff of revisions 3 and 1 (-want +got):\n%v", diff)
+	}
+	if _, err := s.RevisionDiff(context.Background(), 1, 4); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v for the diff with an unknown revision, want %v", err, codes.NotFound)
+	}
+
+	if _, err := s.Rollback(NewUserContext(context.Background(), "carol"), 2); err != nil {
+		t.Fatalf("got error %v in rolling back, want nil", err)
+	}
+	if got := *s.config.(*gostruct.Device).System.Config.Hostname; got != "a" {
+		t.Errorf("got hostname %q after rollback, want %q", got, "a")
+	}
+	checkRevisions([]rev{
+		{id: 1, updates: []*pb.Update{hostnameUpdate("startup")}},
+		{id: 2, user: "alice", updates: []*pb.Update{hostnameUpdate("a")}},
+		{id: 3, user: "bob", updates: []*pb.Update{hostnameUpdate("b")}},
+		{id: 4, user: "carol", updates: []*pb.Update{hostnameUpdate("a")}},
+	})
+	if _, err := s.Rollback(context.Background(), 5); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v in rolling back to an unknown revision, want %v", err, codes.NotFound)
+	}
+}
+
+func TestRevisionsAuthorization(t *testing.T) {
+	policy, err := NewPolicy([]byte(`{
+		"rules": [
+			{"path": "/", "users": ["admin"], "access": "write"},
+			{"path": "/", "users": ["alice"], "access": "read"},
+			{"path": "/system/config/domain-name", "users": ["alice"], "access": "deny"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("error in loading policy: %v", err)
+	}
+	s, err := NewServer(model, nil, nil, WithHistorySize(100), WithPathAuthorizer(policy))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	hostname := &pb.Update{
+		Path: mustPath("/system/config/hostname"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "a"}},
+	}
+	domainName := &pb.Update{
+		Path: mustPath("/system/config/domain-name"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "example.com"}},
+	}
+	admin := NewUserContext(context.Background(), "admin")
+	if _, err := s.Set(admin, &pb.SetRequest{Update: []*pb.Update{hostname, domainName}}); err != nil {
+		t.Fatalf("error in setting config: %v", err)
+	}
+
+	tests := []struct {
+		user string
+		want []*pb.Update
+	}{
+		{user: "admin", want: []*pb.Update{domainName, hostname}},
+		{user: "alice", want: []*pb.Update{hostname}},
+		{user: "bob"},
+	}
+	for _, test := range tests {
+		ctx := NewUserContext(context.Background(), test.user)
+		revs := s.Revisions(ctx)
+		if diff := cmp.Diff(test.want, revs[len(revs)-1].Diff.GetUpdate(), protocmp.Transform()); diff != "" {
+			t.Errorf("%s: revision changes diff (-want +got):\n%v", test.user, diff)
+		}
+		diff, err := s.RevisionDiff(ctx, 1, 2)
+		if err != nil {
+			t.Fatalf("%s: got error %v, want nil", test.user, err)
+		}
+		if diff := cmp.Diff(test.want, diff.GetUpdate(), protocmp.Transform()); diff != "" {
+			t.Errorf("%s: diff of revisions 1 and 2 (-want +got):\n%v", test.user, diff)
+		}
+	}
+}
+
+func TestPathAuthorization(t *testing.T) {
+	policy, err := NewPolicy([]byte(`{
+		"groups": {"ops": ["alice", "bob"]},
+		"rules": [
+			{"path": "/", "groups": ["ops"], "access": "read"},
+			{"path": "/system/config", "users": ["alice"], "access": "write"},
+			{"path": "/system/config/domain-name", "groups": ["ops"], "access": "deny"},
+			{"path": "/interfaces/interface[name=*]/config", "users": ["bob"], "access": "write"},
+			{"path": "/interfaces/interface[name=eth1]/config", "users": ["*"], "access": "deny"},
+			{"origin": "cli", "path": "/", "users": ["alice"], "access": "write"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("error in loading policy: %v", err)
+	}
+
+	policyTests := []struct {
+		user      string
+		path      string
+		wantRead  bool
+		wantWrite bool
+	}{
+		{user: "alice", path: "/system/state/hostname", wantRead: true},
+		{user: "alice", path: "/system/config/hostname", wantRead: true, wantWrite: true},
+		{user: "alice", path: "/system/config/domain-name"},
+		{user: "bob", path: "/interfaces/interface[name=eth0]/config/mtu", wantRead: true, wantWrite: true},
+		{user: "bob", path: "/interfaces/interface[name=eth1]/config/mtu"},
+		{user: "carol", path: "/system/state/hostname"},
+	}
+	for _, test := range policyTests {
+		path := mustPath(test.path)
+		if got := policy.CanRead(test.user, path); got != test.wantRead {
+			t.Errorf("CanRead(%q, %s) = %v, want %v", test.user, test.path, got, test.wantRead)
+		}
+		if got := policy.CanWrite(test.user, path); got != test.wantWrite {
+			t.Errorf("CanWrite(%q, %s) = %v, want %v", test.user, test.path, got, test.wantWrite)
+		}
+	}
+
+	jsonConfigRoot := `{
+		"openconfig-system:system": {
+			"config": {"hostname": "switch", "domain-name": "example.com"}
+		},
+		"openconfig-interfaces:interfaces": {
+			"interface": [
+				{"name": "eth0", "config": {"name": "eth0", "mtu": 1500}},
+				{"name": "eth1", "config": {"name": "eth1", "mtu": 1500}}
+			]
+		}
+	}`
+	s, err := NewServer(model, []byte(jsonConfigRoot), nil, WithPathAuthorizer(policy), WithOriginHandler("cli", cliHandler{}))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	ctx := func(user string) context.Context {
+		return NewUserContext(context.Background(), user)
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		resp, err := s.Get(ctx("bob"), &pb.GetRequest{
+			Path:     []*pb.Path{mustPath("/")},
+			Encoding: pb.Encoding_PROTO,
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		want := []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch"}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth0]/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth0"}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth0]/config/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth0"}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth0]/config/mtu"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: 1500}},
+		}, {
+			Path: mustPath("/interfaces/interface[name=eth1]/name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "eth1"}},
+		}}
+		if diff := cmp.Diff(want, resp.GetNotification()[0].GetUpdate(), protocmp.Transform(), cmpopts.SortSlices(updateLess)); diff != "" {
+			t.Errorf("updates diff (-want +got):\n%v", diff)
+		}
+	})
+
+	setTests := []struct {
+		desc        string
+		user        string
+		req         *pb.SetRequest
+		wantRetCode codes.Code
+	}{{
+		desc: "allowed leaf",
+		user: "alice",
+		req: &pb.SetRequest{Update: []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "router"}},
+		}}},
+	}, {
+		desc: "denied leaf",
+		user: "alice",
+		req: &pb.SetRequest{Update: []*pb.Update{{
+			Path: mustPath("/system/config/domain-name"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "example.org"}},
+		}}},
+		wantRetCode: codes.PermissionDenied,
+	}, {
+		desc: "denied leaf in container payload",
+		user: "alice",
+		req: &pb.SetRequest{Replace: []*pb.Update{{
+			Path: mustPath("/system/config"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{"hostname": "router"}`)}},
+		}}},
+		wantRetCode: codes.PermissionDenied,
+	}, {
+		desc: "allowed key leaf of new list entry",
+		user: "bob",
+		req: &pb.SetRequest{Update: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth2]/config"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{"name": "eth2", "mtu": 1500}`)}},
+		}}},
+	}, {
+		desc: "denied key leaf of new list entry with denied leaves",
+		user: "alice",
+		req: &pb.SetRequest{Update: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth3]/config"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{"name": "eth3", "mtu": 1500}`)}},
+		}}},
+		wantRetCode: codes.PermissionDenied,
+	}, {
+		desc:        "denied list entry deletion",
+		user:        "bob",
+		req:         &pb.SetRequest{Delete: []*pb.Path{mustPath("/interfaces/interface[name=eth0]")}},
+		wantRetCode: codes.PermissionDenied,
+	}, {
+		desc: "allowed origin",
+		user: "alice",
+		req: &pb.SetRequest{
+			Prefix: &pb.Path{Origin: "cli"},
+			Update: []*pb.Update{{
+				Path: &pb.Path{},
+				Val:  &pb.TypedValue{Value: &pb.TypedValue_AsciiVal{AsciiVal: "hostname cli-router\n"}},
+			}},
+		},
+	}, {
+		desc: "denied origin",
+		user: "bob",
+		req: &pb.SetRequest{
+			Prefix: &pb.Path{Origin: "cli"},
+			Update: []*pb.Update{{
+				Path: &pb.Path{},
+				Val:  &pb.TypedValue{Value: &pb.TypedValue_AsciiVal{AsciiVal: "hostname cli-router\n"}},
+			}},
+		},
+		wantRetCode: codes.PermissionDenied,
+	}}
+	for _, test := range setTests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := s.Set(ctx(test.user), test.req)
+			if got := status.Code(err); got != test.wantRetCode {
+				t.Errorf("got return code %v, want %v: %v", got, test.wantRetCode, err)
+			}
+		})
+	}
+
+	t.Run("Subscribe", func(t *testing.T) {
+		stream := newFakeSubscribeServer()
+		defer stream.cancel()
+		stream.ctx = NewUserContext(stream.ctx, "bob")
+		go s.Subscribe(stream)
+		stream.reqC <- &pb.SubscribeRequest{
+			Request: &pb.SubscribeRequest_Subscribe{
+				Subscribe: &pb.SubscriptionList{
+					Mode:         pb.SubscriptionList_ONCE,
+					Subscription: []*pb.Subscription{{Path: mustPath("/system/config")}},
+				},
+			},
+		}
+		stream.checkResponses(t, []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "cli-router"}},
+		}})
+	})
+}
+
+func TestAppliedState(t *testing.T) {
+	stateHostname := func(s *Server) string {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if c := s.config.(*gostruct.Device).System; c != nil && c.State != nil {
+			t.Errorf("got state %v in the config, want none", c.State)
+		}
+		if s.state == nil {
+			return ""
+		}
+		if c := s.state.(*gostruct.Device).System; c != nil && c.State != nil && c.State.Hostname != nil {
+			return *c.State.Hostname
+		}
+		return ""
+	}
+	setHostname := func(s *Server, name string) {
+		t.Helper()
+		_, err := s.Set(nil, &pb.SetRequest{Update: []*pb.Update{{
+			Path: mustPath("/system/config/hostname"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: name}},
+		}}})
+		if err != nil {
+			t.Fatalf("error in setting hostname %q: %v", name, err)
+		}
+	}
+
+	s, err := NewServer(model, []byte(`{"openconfig-system:system": {"config": {"hostname": "startup"}}}`), nil, WithAppliedState(0))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	if got := stateHostname(s); got != "startup" {
+		t.Errorf("got state hostname %q at startup, want %q", got, "startup")
+	}
+	setHostname(s, "a")
+	if got := stateHostname(s); got != "a" {
+		t.Errorf("got state hostname %q after setting config, want %q", got, "a")
+	}
+	if _, err := s.Set(nil, &pb.SetRequest{Delete: []*pb.Path{mustPath("/system/config/hostname")}}); err != nil {
+		t.Fatalf("error in deleting hostname: %v", err)
+	}
+	if got := stateHostname(s); got != "" {
+		t.Errorf("got state hostname %q after deleting config, want none", got)
+	}
+
+	// The state of a list entry goes with the entry.
+	pathEth0 := mustPath("/interfaces/interface[name=eth0]")
+	if _, err := s.Set(nil, &pb.SetRequest{Update: []*pb.Update{{
+		Path: pathEth0,
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{"name": "eth0", "config": {"name": "eth0", "mtu": 1500}}`)}},
+	}}}); err != nil {
+		t.Fatalf("error in creating interface: %v", err)
+	}
+	resp, err := s.Get(nil, &pb.GetRequest{Path: []*pb.Path{mustPath("/interfaces/interface[name=eth0]/state/mtu")}, Encoding: pb.Encoding_PROTO})
+	if err != nil {
+		t.Fatalf("error in getting state mtu: %v", err)
+	}
+	if got := resp.GetNotification()[0].GetUpdate()[0].GetVal().GetUintVal(); got != 1500 {
+		t.Errorf("got state mtu %d, want 1500", got)
+	}
+	if _, err := s.Set(nil, &pb.SetRequest{Delete: []*pb.Path{pathEth0}}); err != nil {
+		t.Fatalf("error in deleting interface: %v", err)
+	}
+	if _, err := s.Get(nil, &pb.GetRequest{Path: []*pb.Path{pathEth0}}); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v getting the deleted interface, want %v", err, codes.NotFound)
+	}
+
+	delay := 100 * time.Millisecond
+	s, err = NewServer(model, nil, nil, WithAppliedState(delay))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	setHostname(s, "b")
+	if got := stateHostname(s); got != "" {
+		t.Errorf("got state hostname %q before the delay, want none", got)
+	}
+	time.Sleep(2 * delay)
+	if got := stateHostname(s); got != "b" {
+		t.Errorf("got state hostname %q after the delay, want %q", got, "b")
+	}
+	// The delayed changes are applied in order.
+	for i := 0; i < 20; i++ {
+		setHostname(s, fmt.Sprintf("c%d", i))
+	}
+	time.Sleep(2 * delay)
+	if got := stateHostname(s); got != "c19" {
+		t.Errorf("got state hostname %q after the delay of successive Sets, want %q", got, "c19")
+	}
+}
+
+func TestUpdateState(t *testing.T) {
+	s, err := NewServer(model, []byte(`{
+		"openconfig-interfaces:interfaces": {
+			"interface": [{"name": "eth0", "config": {"name": "eth0", "mtu": 1500}}]
+		}
+	}`), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	pathState := mustPath("/interfaces/interface[name=eth0]/state")
+	pathInOctets := mustPath("/interfaces/interface[name=eth0]/state/counters/in-octets")
+	inOctets := func(v uint64) *pb.Update {
+		return &pb.Update{Path: pathInOctets, Val: &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: v}}}
+	}
+	getInOctets := func() (*pb.Update, error) {
+		resp, err := s.Get(nil, &pb.GetRequest{Path: []*pb.Path{pathInOctets}, Encoding: pb.Encoding_PROTO})
+		if err != nil {
+			return nil, err
+		}
+		return resp.GetNotification()[0].GetUpdate()[0], nil
+	}
+
+	req := &pb.SubscribeRequest{
+		Request: &pb.SubscribeRequest_Subscribe{
+			Subscribe: &pb.SubscriptionList{
+				Mode:        pb.SubscriptionList_STREAM,
+				UpdatesOnly: true,
+				Subscription: []*pb.Subscription{&pb.Subscription{
+					Mode: pb.SubscriptionMode_ON_CHANGE,
+					Path: pathState,
+				}},
+			},
+		},
+	}
+	msgQ := coalesce.NewQueue()
+	defer msgQ.Close()
+	c := &streamClient{sr: req, msgQ: msgQ}
+	cs := s.doOnChangeSubscription(c, req.GetSubscribe().GetSubscription())
+	defer s.removeChangeSubscriber(cs)
+	if _, _, err := msgQ.Next(context.Background()); err != nil {
+		t.Fatalf("error getting sync_response from the queue: %v", err)
+	}
+	nextNotification := func() *pb.Notification {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		msg, _, err := msgQ.Next(ctx)
+		if err != nil {
+			t.Fatalf("error getting message from the queue: %v", err)
+		}
+		n, ok := msg.(*pb.Notification)
+		if !ok || n == nil {
+			t.Fatalf("wanted Notification message in queue, got: %v", msg)
+		}
+		return n
+	}
+
+	if err := s.UpdateState(&pb.Notification{
+		Timestamp: 42,
+		Prefix:    pathState,
+		Update:    []*pb.Update{{Path: mustPath("/counters/in-octets"), Val: inOctets(10).GetVal()}},
+	}); err != nil {
+		t.Fatalf("error in updating state: %v", err)
+	}
+	want := &pb.Notification{Timestamp: 42, Update: []*pb.Update{inOctets(10)}}
+	if diff := cmp.Diff(want, nextNotification(), protocmp.Transform()); diff != "" {
+		t.Errorf("published state update diff (-want +got):\n%s", diff)
+	}
+
+	// The state is kept apart from the config replaced by Set.
+	if _, err := s.Set(nil, &pb.SetRequest{Replace: []*pb.Update{{
+		Path: mustPath("/interfaces/interface[name=eth0]/config"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{"name": "eth0", "mtu": 9000}`)}},
+	}}}); err != nil {
+		t.Fatalf("error in replacing config: %v", err)
+	}
+	got, err := getInOctets()
+	if err != nil {
+		t.Fatalf("error in getting in-octets: %v", err)
+	}
+	if diff := cmp.Diff(inOctets(10), got, protocmp.Transform()); diff != "" {
+		t.Errorf("in-octets after Set diff (-want +got):\n%s", diff)
+	}
+
+	invalidTests := []struct {
+		desc string
+		n    *pb.Notification
+	}{
+		{desc: "config path", n: &pb.Notification{Update: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth0]/config/mtu"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: 1500}},
+		}}}},
+		{desc: "unknown path", n: &pb.Notification{Update: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth0]/state/foo"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: 1}},
+		}}}},
+		{desc: "invalid value", n: &pb.Notification{Update: []*pb.Update{{
+			Path: mustPath("/interfaces/interface[name=eth0]/state/oper-status"),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "SIDEWAYS"}},
+		}}}},
+	}
+	for _, test := range invalidTests {
+		// The valid update is not applied along with the invalid one.
+		err := s.UpdateState(&pb.Notification{Update: []*pb.Update{inOctets(20)}}, test.n)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: got error %v, want InvalidArgument", test.desc, err)
+		}
+	}
+	if msgQ.Len() != 0 {
+		t.Errorf("got %d messages in the queue after invalid state updates, want 0", msgQ.Len())
+	}
+
+	if err := s.UpdateState(&pb.Notification{Delete: []*pb.Path{pathInOctets}}); err != nil {
+		t.Fatalf("error in deleting state: %v", err)
+	}
+	want = &pb.Notification{Delete: []*pb.Path{pathInOctets}}
+	if diff := cmp.Diff(want, nextNotification(), protocmp.Transform(), protocmp.IgnoreFields(&pb.Notification{}, "timestamp")); diff != "" {
+		t.Errorf("published state delete diff (-want +got):\n%s", diff)
+	}
+	if _, err := getInOctets(); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v in getting deleted in-octets, want NotFound", err)
+	}
+}
+
+func TestSnapshotReads(t *testing.T) {
+	applying := make(chan string)
+	release := make(chan struct{})
+	callback := func(config ygot.ValidatedGoStruct) error {
+		if c := config.(*gostruct.Device).System; c != nil && c.Config != nil && c.Config.Hostname != nil && *c.Config.Hostname != "switch_a" {
+			applying <- *c.Config.Hostname
+			<-release
+		}
+		return nil
+	}
+	s, err := NewServer(model, []byte(`{"openconfig-system:system": {"config": {"hostname": "switch_a"}}}`), callback)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	pathHostname := mustPath("/system/config/hostname")
+	setErrC := make(chan error, 2)
+	setHostname := func(name string, exts ...*extpb.Extension) {
+		_, err := s.Set(nil, &pb.SetRequest{Update: []*pb.Update{{
+			Path: pathHostname,
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: name}},
+		}}, Extension: exts})
+		setErrC <- err
+	}
+	checkHostname := func(want string) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := s.Get(ctx, &pb.GetRequest{Path: []*pb.Path{pathHostname}, Encoding: pb.Encoding_PROTO})
+		if err != nil {
+			t.Errorf("error in getting hostname: %v", err)
+			return
+		}
+		if got := resp.GetNotification()[0].GetUpdate()[0].GetVal().GetStringVal(); got != want {
+			t.Errorf("got hostname %q, want %q", got, want)
+		}
+	}
+
+	primary := &extpb.Extension{Ext: &extpb.Extension_MasterArbitration{
+		MasterArbitration: &extpb.MasterArbitration{ElectionId: &extpb.Uint128{Low: 5}},
+	}}
+	go setHostname("switch_b", primary)
+	if got := <-applying; got != "switch_b" {
+		t.Fatalf("got hostname %q applied first, want %q", got, "switch_b")
+	}
+	// The Gets do not wait for the Set applying its config to the device.
+	getDone := make(chan struct{})
+	go func() {
+		defer close(getDone)
+		checkHostname("switch_a")
+		resp, err := s.Get(nil, &pb.GetRequest{
+			Prefix: &pb.Path{Origin: DiagnosticsOrigin},
+			Path:   []*pb.Path{mustPath("/master-arbitration")},
+		})
+		if err != nil {
+			t.Errorf("error in getting the primaries: %v", err)
+			return
+		}
+		want := `{"role":[{"election-id":{"high":"0","low":"5"},"id":""}]}`
+		if got := string(resp.GetNotification()[0].GetUpdate()[0].GetVal().GetJsonIetfVal()); got != want {
+			t.Errorf("got primaries %s, want %s", got, want)
+		}
+	}()
+	select {
+	case <-getDone:
+	case <-time.After(time.Second):
+		t.Fatal("Get blocked by the Set applying its config")
+	}
+
+	// The writers are serialized.
+	go setHostname("switch_c", primary)
+	select {
+	case got := <-applying:
+		t.Fatalf("hostname %q applied while another Set is applying its config", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+	release <- struct{}{}
+	if err := <-setErrC; err != nil {
+		t.Fatalf("error in setting hostname: %v", err)
+	}
+	checkHostname("switch_b")
+	<-applying
+	release <- struct{}{}
+	if err := <-setErrC; err != nil {
+		t.Fatalf("error in setting hostname: %v", err)
+	}
+	checkHostname("switch_c")
+}
+
+func TestSubscribeSharedSampling(t *testing.T) {
+	s, err := NewServer(model, []byte(`{"openconfig-system:system": {"config": {"hostname": "switch_a"}}}`), nil, WithSubscriberQueueLimit(2))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	pathConfig := mustPath("/system/config")
+	hostnameUpdate := &pb.Update{
+		Path: mustPath("/system/config/hostname"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_a"}},
+	}
+	subscribe := func(mode pb.SubscriptionMode) (*fakeSubscribeServer, <-chan error) {
+		stream := newFakeSubscribeServer()
+		errC := make(chan error, 1)
+		go func() { errC <- s.Subscribe(stream) }()
+		stream.reqC <- &pb.SubscribeRequest{
+			Request: &pb.SubscribeRequest_Subscribe{
+				Subscribe: &pb.SubscriptionList{
+					Mode:         pb.SubscriptionList_STREAM,
+					Encoding:     pb.Encoding_PROTO,
+					Subscription: []*pb.Subscription{{Path: pathConfig, Mode: mode, SampleInterval: uint64(time.Second)}},
+				},
+			},
+		}
+		return stream, errC
+	}
+	diagnostics := func() map[string]interface{} {
+		t.Helper()
+		resp, err := s.Get(context.Background(), &pb.GetRequest{
+			Prefix: &pb.Path{Origin: DiagnosticsOrigin},
+			Path:   []*pb.Path{mustPath("/subscriptions")},
+		})
+		if err != nil {
+			t.Fatalf("error in getting subscription diagnostics: %v", err)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(resp.GetNotification()[0].GetUpdate()[0].GetVal().GetJsonIetfVal(), &got); err != nil {
+			t.Fatalf("error in unmarshaling subscription diagnostics: %v", err)
+		}
+		return got
+	}
+
+	var streams []*fakeSubscribeServer
+	for i := 0; i < 3; i++ {
+		stream, _ := subscribe(pb.SubscriptionMode_SAMPLE)
+		defer stream.cancel()
+		stream.checkResponses(t, []*pb.Update{hostnameUpdate})
+		streams = append(streams, stream)
+	}
+	want := map[string]interface{}{"stream-clients": "3", "sample-groups": "1", "slow-consumers-disconnected": "0"}
+	if diff := cmp.Diff(want, diagnostics()); diff != "" {
+		t.Errorf("diagnostics of the SAMPLE subscriptions diff (-want +got):\n%s", diff)
+	}
+	// Every client gets the values sampled once for all of them.
+	for i, stream := range streams {
+		select {
+		case resp := <-stream.respC:
+			if diff := cmp.Diff([]*pb.Update{hostnameUpdate}, resp.GetUpdate().GetUpdate(), protocmp.Transform()); diff != "" {
+				t.Errorf("client %d: sampled Updates diff (-want +got):\n%s", i, diff)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("client %d: timeout waiting for a sample", i)
+		}
+	}
+
+	// A client not reading its responses is disconnected once its queue is
+	// full.
+	stream, errC := subscribe(pb.SubscriptionMode_ON_CHANGE)
+	defer stream.cancel()
+	stream.checkResponses(t, []*pb.Update{hostnameUpdate})
+	for i := 0; i < 20; i++ {
+		if _, err := s.Set(nil, &pb.SetRequest{Update: []*pb.Update{{
+			Path: hostnameUpdate.GetPath(),
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: fmt.Sprintf("switch_%d", i)}},
+		}}}); err != nil {
+			t.Fatalf("error in setting hostname: %v", err)
+		}
+	}
+	select {
+	case err := <-errC:
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("got error %v from the slow subscriber, want ResourceExhausted", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow subscriber not disconnected")
+	}
+	if got := diagnostics()["slow-consumers-disconnected"]; got != "1" {
+		t.Errorf("got %v slow consumers disconnected, want 1", got)
+	}
+
+	for _, stream := range streams {
+		stream.cancel()
+	}
+	for deadline := time.Now().Add(time.Second); s.sampler.groupCount() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d sample groups running after the clients left, want 0", s.sampler.groupCount())
+		}
+	}
+}
+
+func TestSubscribeMissingData(t *testing.T) {
+	s, err := NewServer(model, []byte(`{"openconfig-system:system": {"config": {"hostname": "switch_a"}}}`), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	subscribe := func(path string, mode pb.SubscriptionMode) (*fakeSubscribeServer, <-chan error) {
+		stream := newFakeSubscribeServer()
+		errC := make(chan error, 1)
+		go func() { errC <- s.Subscribe(stream) }()
+		stream.reqC <- &pb.SubscribeRequest{
+			Request: &pb.SubscribeRequest_Subscribe{
+				Subscribe: &pb.SubscriptionList{
+					Mode:         pb.SubscriptionList_STREAM,
+					Encoding:     pb.Encoding_PROTO,
+					Subscription: []*pb.Subscription{{Path: mustPath(path), Mode: mode, SampleInterval: uint64(time.Second)}},
+				},
+			},
+		}
+		return stream, errC
+	}
+	// next returns the next Notification message sent to the client.
+	next := func(stream *fakeSubscribeServer, timeout time.Duration) *pb.Notification {
+		t.Helper()
+		select {
+		case resp := <-stream.respC:
+			return resp.GetUpdate()
+		case <-time.After(timeout):
+			t.Fatal("timeout waiting for a response")
+		}
+		return nil
+	}
+
+	stream, errC := subscribe("/interfaces/interface[name=*]/config/foo", pb.SubscriptionMode_ON_CHANGE)
+	if err := <-errC; status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v for a path not in the schema, want %v", err, codes.InvalidArgument)
+	}
+	stream.cancel()
+
+	// No interface exists yet.
+	pattern := "/interfaces/interface[name=*]/config/description"
+	onChange, _ := subscribe(pattern, pb.SubscriptionMode_ON_CHANGE)
+	defer onChange.cancel()
+	sample, _ := subscribe(pattern, pb.SubscriptionMode_SAMPLE)
+	defer sample.cancel()
+	for _, stream := range []*fakeSubscribeServer{onChange, sample} {
+		select {
+		case resp := <-stream.respC:
+			if !resp.GetSyncResponse() {
+				t.Errorf("got %v, want a sync_response", resp)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for the sync_response")
+		}
+	}
+
+	descPath := mustPath("/interfaces/interface[name=eth0]/config/description")
+	wantUpdate := []*pb.Update{{Path: descPath, Val: &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "uplink"}}}}
+	if _, err := s.Set(nil, &pb.SetRequest{Update: []*pb.Update{{
+		Path: mustPath("/interfaces/interface[name=eth0]"),
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_JsonIetfVal{JsonIetfVal: []byte(`{"name": "eth0", "config": {"name": "eth0", "description": "uplink"}}`)}},
+	}}}); err != nil {
+		t.Fatalf("error in creating interface: %v", err)
+	}
+	if diff := cmp.Diff(wantUpdate, next(onChange, time.Second).GetUpdate(), protocmp.Transform()); diff != "" {
+		t.Errorf("ON_CHANGE Updates of the interface created diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(wantUpdate, next(sample, 2*time.Second).GetUpdate(), protocmp.Transform()); diff != "" {
+		t.Errorf("SAMPLE Updates of the interface created diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := s.Set(nil, &pb.SetRequest{Delete: []*pb.Path{mustPath("/interfaces/interface[name=eth0]")}}); err != nil {
+		t.Fatalf("error in deleting interface: %v", err)
+	}
+	if diff := cmp.Diff([]*pb.Path{descPath}, next(onChange, time.Second).GetDelete(), protocmp.Transform()); diff != "" {
+		t.Errorf("ON_CHANGE Deletes of the interface deleted diff (-want +got):\n%s", diff)
+	}
+	// The description may be sampled once more before the delete.
+	n := next(sample, 2*time.Second)
+	if len(n.GetDelete()) == 0 {
+		n = next(sample, 2*time.Second)
+	}
+	if diff := cmp.Diff([]*pb.Path{descPath}, n.GetDelete(), protocmp.Transform()); diff != "" {
+		t.Errorf("SAMPLE Deletes of the interface deleted diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSubscribeOnce(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-system:system": {
+			"openconfig-openflow:openflow": {
+				"agent": {
+					"state": {
+						"failure-mode": "SECURE",
+						"max-backoff": 10
+					}
+				}
+			}
+		},
+	"openconfig-platform:components": {
+	    "component": [
+	      {
+	        "state": {
+			  "oper-status": "ACTIVE",
+			  "description": "linecard",
+			  "mfg-name": "acme"
+	        },
+	        "name": "swpri1-1-1",
+			"config": {
+				"name": "swpri1-1-1"
+			}
+	      },
+		  {
+	        "state": {
+			  "oper-status": "INACTIVE"
+	        },
+	        "name": "swpri2-2-2",
+			"config": {
+				"name": "swpri2-2-2"
+			}
+	      }
+	    ]
+	}
+}`
+	pathAgentState := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "system"},
+			&pb.PathElem{Name: "openflow"},
+			&pb.PathElem{Name: "agent"},
+			&pb.PathElem{Name: "state"},
+		}}
+	pathAgentFailureMode := proto.Clone(pathAgentState).(*pb.Path)
+	pathAgentFailureMode.Elem = append(pathAgentFailureMode.Elem, &pb.PathElem{Name: "failure-mode"})
+	pathAgentMaxBackoff := proto.Clone(pathAgentState).(*pb.Path)
+	pathAgentMaxBackoff.Elem = append(pathAgentMaxBackoff.Elem, &pb.PathElem{Name: "max-backoff"})
+	pathAgentFoo := proto.Clone(pathAgentState).(*pb.Path)
+	pathAgentFoo.Elem = append(pathAgentMaxBackoff.Elem, &pb.PathElem{Name: "foo"})
+	pathComponentSw1State := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "components"},
+			&pb.PathElem{Name: "component", Key: map[string]string{"name": "swpri1-1-1"}},
+			&pb.PathElem{Name: "state"},
+		}}
+	pathComponentSw2Oper := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "components"},
+			&pb.PathElem{Name: "component", Key: map[string]string{"name": "swpri2-2-2"}},
+			&pb.PathElem{Name: "state"},
+			&pb.PathElem{Name: "oper-status"},
+		}}
+	pathComponentSw3State := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "components"},
+			&pb.PathElem{Name: "component", Key: map[string]string{"name": "swpri3-3-3"}},
+			&pb.PathElem{Name: "state"},
+		}}
+	pathComponentStartOper := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "components"},
+			&pb.PathElem{Name: "component", Key: map[string]string{"name": "*"}},
+			&pb.PathElem{Name: "state"},
+			&pb.PathElem{Name: "oper-status"},
+		}}
+	pathComponentStartState := proto.Clone(pathComponentStartOper).(*pb.Path)
+	pathComponentStartState.Elem = pathComponentStartState.Elem[:len(pathComponentStartState.Elem)-1]
+	pathComponentSw1Oper := proto.Clone(pathComponentSw1State).(*pb.Path)
+	pathComponentSw1Oper.Elem = append(pathComponentSw1Oper.Elem, &pb.PathElem{Name: "oper-status"})
+	pathComponentSw1Description := proto.Clone(pathComponentSw1State).(*pb.Path)
+	pathComponentSw1Description.Elem = append(pathComponentSw1Description.Elem, &pb.PathElem{Name: "description"})
+	pathComponentSw1MfgName := proto.Clone(pathComponentSw1State).(*pb.Path)
+	pathComponentSw1MfgName.Elem = append(pathComponentSw1MfgName.Elem, &pb.PathElem{Name: "mfg-name"})
+	pathComponentSw1Star := proto.Clone(pathComponentSw1State).(*pb.Path)
+	pathComponentSw1Star.Elem[len(pathComponentSw1Star.Elem)-1].Name = "*"
+
+	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+
+	tests := []struct {
+		desc              string
+		subscriptions     []*pb.Subscription
+		pathPrefix        *pb.Path
+		updatesOnly       bool
+		wantError         error
+		wantNotifications []*pb.Notification
+	}{{
+		desc: "Subscribe to leaf node",
+		subscriptions: []*pb.Subscription{
+			&pb.Subscription{
+				Path: pathAgentFailureMode}},
+		wantNotifications: []*pb.Notification{
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathAgentFailureMode,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}}}}},
+	}, {
+		desc: "Subscribe to multiple leaf nodes",
+		subscriptions: []*pb.Subscription{
+			&pb.Subscription{
+				Path: pathAgentFailureMode},
+			&pb.Subscription{
+				Path: pathAgentMaxBackoff}},
+		wantNotifications: []*pb.Notification{
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathAgentFailureMode,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}}}},
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathAgentMaxBackoff,
+						Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(10)}}}}}},
+	}, {
+		desc: "Subscribe to container node",
+		subscriptions: []*pb.Subscription{
+			&pb.Subscription{
+				Path: pathAgentState}},
+		wantNotifications: []*pb.Notification{
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathAgentFailureMode,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
+					&pb.Update{
+						Path: pathAgentMaxBackoff,
+						Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(10)}}}}}},
+	}, {
+		desc: "Subscribe to container and leaf nodes",
+		subscriptions: []*pb.Subscription{
+			&pb.Subscription{
+				Path: pathAgentState},
+			&pb.Subscription{
+				Path: pathAgentFailureMode},
+		},
+		wantNotifications: []*pb.Notification{
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathAgentFailureMode,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
+					&pb.Update{
+						Path: pathAgentMaxBackoff,
+						Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(10)}}}}},
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathAgentFailureMode,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}}}}},
+	}, {
+		desc:       "Subscribe with prefix",
+		pathPrefix: pathAgentState,
+		subscriptions: []*pb.Subscription{
+			&pb.Subscription{
+				Path: &pb.Path{Elem: []*pb.PathElem{&pb.PathElem{Name: "failure-mode"}}}}},
+		wantNotifications: []*pb.Notification{
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathAgentFailureMode,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}}}}},
+	}, {
+		desc: "Subscribe to keyed path",
+		subscriptions: []*pb.Subscription{
+			&pb.Subscription{
+				Path: pathComponentSw1Oper}},
+		wantNotifications: []*pb.Notification{
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathComponentSw1Oper,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "ACTIVE"}}}}}},
+	}, {
+		desc: "Subscribe to leaf node with wildcard key",
+		subscriptions: []*pb.Subscription{
+			&pb.Subscription{
+				Path: pathComponentStartOper}},
+		wantNotifications: []*pb.Notification{
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathComponentSw1Oper,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "ACTIVE"}}},
+					&pb.Update{
+						Path: pathComponentSw2Oper,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "INACTIVE"}}}}}},
+	}, {
+		desc: "Subscribe to container node of keyed path",
+		subscriptions: []*pb.Subscription{
+			&pb.Subscription{
+				Path: pathComponentSw1State}},
+		wantNotifications: []*pb.Notification{
+			&pb.Notification{
+				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathComponentSw1Description,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "linecard"}}},
+					&pb.Update{
+						Path: pathComponentSw1MfgName,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "acme"}}},
+					&pb.Update{
+						Path: pathComponentSw1Oper,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "ACTIVE"}}}}}},
+	}, {
+		desc: "Subscribe to container node with wildcard key",
+		subscriptions: []*pb.Subscription{
+			&pb.Subscription{
 				Path: pathComponentStartState}},
 		wantNotifications: []*pb.Notification{
 			&pb.Notification{
 				Update: []*pb.Update{
+					&pb.Update{
+						Path: pathComponentSw1Description,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "linecard"}}},
+					&pb.Update{
+						Path: pathComponentSw1MfgName,
+						Val: &pb.TypedValue{
+							Value: &pb.TypedValue_StringVal{StringVal: "acme"}}},
 					&pb.Update{
 						Path: pathComponentSw1Oper,
 						Val: &pb.TypedValue{
@@ -1398,290 +3770,1000 @@ func TestSubscribeOnce(t *testing.T) {
 		subscriptions: []*pb.Subscription{&pb.Subscription{Path: pathAgentFoo}},
 		wantError:     status.Errorf(codes.NotFound, "path %v not found", pathAgentFoo),
 	}, {
-		desc:          "Subscribe to not-found key",
-		subscriptions: []*pb.Subscription{&pb.Subscription{Path: pathComponentSw3State}},
-		wantError:     status.Errorf(codes.NotFound, "path %v not found", pathComponentSw3State),
+		desc:          "Subscribe to not-found key",
+		subscriptions: []*pb.Subscription{&pb.Subscription{Path: pathComponentSw3State}},
+		wantError:     status.Errorf(codes.NotFound, "path %v not found", pathComponentSw3State),
+	}, {
+		desc:          "Subscribe to updates only",
+		subscriptions: []*pb.Subscription{&pb.Subscription{Path: pathAgentState}},
+		updatesOnly:   true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			runTestSubscribeOnce(t, s, test.subscriptions, test.pathPrefix, test.wantError, test.wantNotifications, test.updatesOnly)
+		})
+	}
+}
+
+// runTestSubscribeOnce requests a ONCE subscription to a path, and compares the returned Updates.
+func runTestSubscribeOnce(t *testing.T, s *Server, subscribptions []*pb.Subscription, pathPrefix *pb.Path, wantError error, wantNotifications []*pb.Notification, updatesOnly bool) {
+
+	req := &pb.SubscribeRequest{
+		Request: &pb.SubscribeRequest_Subscribe{
+			Subscribe: &pb.SubscriptionList{
+				Prefix:       pathPrefix,
+				Mode:         pb.SubscriptionList_ONCE,
+				UpdatesOnly:  updatesOnly,
+				Subscription: subscribptions,
+			},
+		},
+	}
+
+	errC := make(chan error)
+	defer close(errC)
+	msgQ := coalesce.NewQueue()
+	c := &streamClient{sr: req, stream: nil, errC: errC, msgQ: msgQ}
+
+	go s.doOnceSubscription(c)
+	go func() {
+		for {
+			time.Sleep(time.Second)
+			if msgQ.IsClosed() {
+				errC <- nil
+				return
+			}
+		}
+	}()
+
+	gotErr := <-errC
+	if gotErr != nil && wantError == nil {
+		t.Fatalf("got a error, wanted nil: %v", gotErr)
+	}
+	if wantError != nil {
+		if gotErr == nil {
+			t.Fatalf("wanted an error, got nil: %v", wantError)
+		}
+		if errors.Is(gotErr, wantError) {
+			t.Fatalf("wanted error: %v, got error: %v", wantError, gotErr)
+		}
+		return
+	}
+
+	// Check response value
+	gotSync := false
+	var gotNotifications []*pb.Notification
+	for {
+		msg, _, err := c.msgQ.Next(context.Background())
+		if err != nil {
+			if coalesce.IsClosedQueue(err) {
+				break
+			} else {
+				t.Fatalf("Error getting message from the queue: %v", err)
+			}
+		}
+
+		if _, ok := msg.(subscribeSyncToken); ok {
+			gotSync = true
+			continue
+		}
+
+		n, ok := msg.(*pb.Notification)
+		if !ok || n == nil {
+			t.Fatalf("invalid message in queue: %v", msg)
+		}
+		gotNotifications = append(gotNotifications, n)
+	}
+	if diff := cmp.Diff(gotNotifications, wantNotifications, protocmp.Transform(),
+		protocmp.SortRepeated(updateLess),
+		protocmp.IgnoreFields(&pb.Notification{}, "timestamp")); diff != "" {
+		t.Errorf("Updates diff:\n%v", diff)
+	}
+	if !gotSync {
+		t.Errorf("did not receive sync_response message")
+	}
+}
+
+func TestSubscribeSample(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-system:system": {
+			"openconfig-openflow:openflow": {
+				"agent": {
+					"state": {
+						"failure-mode": "SECURE",
+						"max-backoff": 10
+					}
+				}
+			}
+		}
+}`
+	pathAgentState := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "system"},
+			&pb.PathElem{Name: "openflow"},
+			&pb.PathElem{Name: "agent"},
+			&pb.PathElem{Name: "state"},
+		}}
+	pathAgentFailureMode := proto.Clone(pathAgentState).(*pb.Path)
+	pathAgentFailureMode.Elem = append(pathAgentFailureMode.Elem, &pb.PathElem{Name: "failure-mode"})
+	pathAgentMaxBackoff := proto.Clone(pathAgentState).(*pb.Path)
+	pathAgentMaxBackoff.Elem = append(pathAgentMaxBackoff.Elem, &pb.PathElem{Name: "max-backoff"})
+	pathAgentFoo := proto.Clone(pathAgentState).(*pb.Path)
+	pathAgentFoo.Elem = append(pathAgentMaxBackoff.Elem, &pb.PathElem{Name: "foo"})
+
+	secsToNanoSecs := func(s int) uint64 { return uint64(s * 1000000000) }
+
+	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+
+	tests := []struct {
+		desc              string
+		pathPrefix        *pb.Path
+		subscription      *pb.Subscription
+		timeout           time.Duration
+		updatesOnly       bool
+		wantUpdates       []*pb.Update
+		wantNotifications int
+	}{{
+		desc: "Single Sample Subscription",
+		subscription: &pb.Subscription{
+			Mode:           pb.SubscriptionMode_SAMPLE,
+			SampleInterval: secsToNanoSecs(1),
+			Path:           pathAgentFailureMode},
+		timeout:           time.Millisecond * 3500,
+		wantNotifications: 4,
+		wantUpdates: []*pb.Update{
+			&pb.Update{
+				Path: pathAgentFailureMode,
+				Val: &pb.TypedValue{
+					Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
+		},
+	}, {
+		desc: "Single Subscribe to Update Only with 0 interval",
+		subscription: &pb.Subscription{
+			Mode:           pb.SubscriptionMode_SAMPLE,
+			SampleInterval: 0,
+			Path:           pathAgentState},
+		timeout:           time.Millisecond * 3500,
+		wantNotifications: 3,
+		updatesOnly:       true,
+		wantUpdates: []*pb.Update{
+			&pb.Update{
+				Path: pathAgentFailureMode,
+				Val: &pb.TypedValue{
+					Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
+			&pb.Update{
+				Path: pathAgentMaxBackoff,
+				Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(10)}}},
+		},
+	}, {
+		desc:       "Subscribe with prefix",
+		pathPrefix: pathAgentState,
+		subscription: &pb.Subscription{
+			Mode:           pb.SubscriptionMode_SAMPLE,
+			SampleInterval: secsToNanoSecs(2),
+			Path:           &pb.Path{Elem: []*pb.PathElem{&pb.PathElem{Name: "failure-mode"}}}},
+		timeout:           time.Millisecond * 4500,
+		wantNotifications: 3,
+		wantUpdates: []*pb.Update{
+			&pb.Update{
+				Path: pathAgentFailureMode,
+				Val: &pb.TypedValue{
+					Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			runTestSubscribeSample(t, s, test.subscription, test.pathPrefix, test.updatesOnly, test.timeout, test.wantNotifications, test.wantUpdates)
+		})
+	}
+}
+
+// runTestSubscribeSample requests STREAM sampling subscription, and compares the returned Notifications.
+func runTestSubscribeSample(t *testing.T, s *Server, subscription *pb.Subscription, pathPrefix *pb.Path, updatesOnly bool, timeout time.Duration, wantNotifications int, wantUpdates []*pb.Update,
+) {
+	req := &pb.SubscribeRequest{
+		Request: &pb.SubscribeRequest_Subscribe{
+			Subscribe: &pb.SubscriptionList{
+				Prefix:       pathPrefix,
+				Mode:         pb.SubscriptionList_STREAM,
+				UpdatesOnly:  updatesOnly,
+				Subscription: []*pb.Subscription{subscription},
+			},
+		},
+	}
+
+	sampleInterval := subscription.GetSampleInterval()
+	if sampleInterval == 0 {
+		sampleInterval = uint64(minStreamSampleInterval.Nanoseconds())
+	}
+	interval := time.Nanosecond * time.Duration(sampleInterval)
+
+	errC := make(chan error)
+	doneC := make(chan bool)
+	defer close(errC)
+	msgQ := coalesce.NewQueue()
+	c := &streamClient{sr: req, stream: nil, errC: errC, msgQ: msgQ}
+
+	go s.doSampleSubscription(c, subscription, doneC)
+
+	time.Sleep(timeout)
+	close(doneC)
+	msgQ.Close()
+
+	gotNotifications := 0
+	lastNotificationTimestamp := int64(time.Now().UnixNano())
+
+	if !updatesOnly && wantNotifications > 0 {
+		msg, _, err := c.msgQ.Next(context.Background())
+		if err != nil {
+			t.Fatalf("Error getting initial Notification from the queue: %v", err)
+		}
+		n, ok := msg.(*pb.Notification)
+		if !ok || n == nil {
+			t.Fatalf("wanted Notification message in queue, got: %v", msg)
+		}
+		lastNotificationTimestamp = n.GetTimestamp()
+		gotNotifications = gotNotifications + 1
+		if diff := cmp.Diff(n.GetUpdate(), wantUpdates, protocmp.Transform(), protocmp.SortRepeated(updateLess)); diff != "" {
+			t.Errorf("Initial Notification Updates diff:\n%v", diff)
+		}
+	}
+
+	msg, _, err := c.msgQ.Next(context.Background())
+	if err != nil {
+		t.Fatalf("Error getting sync_response from the queue: %v", err)
+	}
+	if _, ok := msg.(subscribeSyncToken); !ok {
+		t.Fatalf("did not receive sync_response message")
+	}
+
+	for {
+		msg, _, err := c.msgQ.Next(context.Background())
+		if err != nil {
+			if coalesce.IsClosedQueue(err) {
+				break
+			} else {
+				t.Fatalf("Error getting Notifications from the queue: %v", err)
+			}
+		}
+
+		if _, ok := msg.(subscribeSyncToken); ok {
+			t.Fatalf("received unexpected sync_response message")
+		}
+
+		n, ok := msg.(*pb.Notification)
+		if !ok || n == nil {
+			t.Fatalf("wanted Notification message in queue, got: %v", msg)
+		}
+		gotNotifications = gotNotifications + 1
+		timeDiff := time.Nanosecond * time.Duration(n.GetTimestamp()-lastNotificationTimestamp)
+		lastNotificationTimestamp = n.GetTimestamp()
+		if (timeDiff - interval) > interval {
+			t.Errorf("Notification messages not within sampling interval: %v", timeDiff)
+		}
+		if diff := cmp.Diff(n.GetUpdate(), wantUpdates, protocmp.Transform(), cmpopts.SortSlices(updateLess)); diff != "" {
+			t.Errorf("Notification Updates diff:\n%v", diff)
+		}
+	}
+
+	if diff := cmp.Diff(wantNotifications, gotNotifications); diff != "" {
+		t.Errorf("wanted %d Notifications, got %d", wantNotifications, gotNotifications)
+	}
+
+}
+
+func TestSubscribeSampleSuppressRedundant(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-system:system": {
+			"openconfig-openflow:openflow": {
+				"agent": {
+					"state": {
+						"failure-mode": "SECURE",
+						"max-backoff": 10
+					}
+				}
+			}
+		}
+	}`
+	pathAgentState := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "system"},
+			&pb.PathElem{Name: "openflow"},
+			&pb.PathElem{Name: "agent"},
+			&pb.PathElem{Name: "state"},
+		}}
+	pathAgentFailureMode := proto.Clone(pathAgentState).(*pb.Path)
+	pathAgentFailureMode.Elem = append(pathAgentFailureMode.Elem, &pb.PathElem{Name: "failure-mode"})
+	pathAgentMaxBackoff := proto.Clone(pathAgentState).(*pb.Path)
+	pathAgentMaxBackoff.Elem = append(pathAgentMaxBackoff.Elem, &pb.PathElem{Name: "max-backoff"})
+	allUpdates := []*pb.Update{
+		&pb.Update{
+			Path: pathAgentFailureMode,
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
+		&pb.Update{
+			Path: pathAgentMaxBackoff,
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: 10}}},
+	}
+
+	tests := []struct {
+		desc              string
+		heartbeatInterval time.Duration
+		change            bool
+		wantUpdates       [][]*pb.Update
+	}{{
+		desc:        "unchanged values are sent once",
+		wantUpdates: [][]*pb.Update{allUpdates},
+	}, {
+		desc:   "changed values are sent",
+		change: true,
+		wantUpdates: [][]*pb.Update{allUpdates, []*pb.Update{
+			&pb.Update{
+				Path: pathAgentMaxBackoff,
+				Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: 20}}},
+		}},
 	}, {
-		desc:          "Subscribe to updates only",
-		subscriptions: []*pb.Subscription{&pb.Subscription{Path: pathAgentState}},
-		updatesOnly:   true,
+		desc:              "all values are sent on heartbeat",
+		heartbeatInterval: 2 * time.Second,
+		wantUpdates:       [][]*pb.Update{allUpdates, allUpdates},
 	}}
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
-			runTestSubscribeOnce(t, s, test.subscriptions, test.pathPrefix, test.wantError, test.wantNotifications, test.updatesOnly)
+			s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+			if err != nil {
+				t.Fatalf("error in creating server: %v", err)
+			}
+			sub := &pb.Subscription{
+				Mode:              pb.SubscriptionMode_SAMPLE,
+				SampleInterval:    uint64(time.Second.Nanoseconds()),
+				SuppressRedundant: true,
+				HeartbeatInterval: uint64(test.heartbeatInterval.Nanoseconds()),
+				Path:              pathAgentState,
+			}
+			req := &pb.SubscribeRequest{
+				Request: &pb.SubscribeRequest_Subscribe{
+					Subscribe: &pb.SubscriptionList{
+						Mode:         pb.SubscriptionList_STREAM,
+						Subscription: []*pb.Subscription{sub},
+					},
+				},
+			}
+			msgQ := coalesce.NewQueue()
+			c := &streamClient{sr: req, msgQ: msgQ}
+			doneC := make(chan bool)
+			go s.doSampleSubscription(c, sub, doneC)
+
+			if test.change {
+				time.Sleep(1500 * time.Millisecond)
+				s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+					config.(*gostruct.Device).System.Openflow.Agent.State.MaxBackoff = ygot.Uint32(20)
+					return nil
+				})
+				time.Sleep(2 * time.Second)
+			} else {
+				time.Sleep(3500 * time.Millisecond)
+			}
+			close(doneC)
+			msgQ.Close()
+
+			var gotUpdates [][]*pb.Update
+			for {
+				msg, _, err := msgQ.Next(context.Background())
+				if err != nil {
+					if coalesce.IsClosedQueue(err) {
+						break
+					}
+					t.Fatalf("Error getting Notifications from the queue: %v", err)
+				}
+				if n, ok := msg.(*pb.Notification); ok {
+					gotUpdates = append(gotUpdates, n.GetUpdate())
+				}
+			}
+			if diff := cmp.Diff(test.wantUpdates, gotUpdates, protocmp.Transform(), protocmp.SortRepeated(updateLess), cmpopts.SortSlices(updateLess)); diff != "" {
+				t.Errorf("Notification Updates diff (-want +got):\n%v", diff)
+			}
 		})
 	}
 }
 
-// runTestSubscribeOnce requests a ONCE subscription to a path, and compares the returned Updates.
-func runTestSubscribeOnce(t *testing.T, s *Server, subscribptions []*pb.Subscription, pathPrefix *pb.Path, wantError error, wantNotifications []*pb.Notification, updatesOnly bool) {
+func TestSubscribeOnChange(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-system:system": {
+			"config": {
+				"hostname": "switch_a",
+				"login-banner": "Hello!"
+			},
+			"openconfig-openflow:openflow": {
+				"agent": {
+					"config": {
+						"failure-mode": "SECURE",
+						"max-backoff": 10
+					}
+				}
+			}
+		}
+	}`
+	pathSystemConfig := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "system"},
+			&pb.PathElem{Name: "config"},
+		}}
+	pathHostname := proto.Clone(pathSystemConfig).(*pb.Path)
+	pathHostname.Elem = append(pathHostname.Elem, &pb.PathElem{Name: "hostname"})
+	pathLoginBanner := proto.Clone(pathSystemConfig).(*pb.Path)
+	pathLoginBanner.Elem = append(pathLoginBanner.Elem, &pb.PathElem{Name: "login-banner"})
+	pathMaxBackoff := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "system"},
+			&pb.PathElem{Name: "openflow"},
+			&pb.PathElem{Name: "agent"},
+			&pb.PathElem{Name: "config"},
+			&pb.PathElem{Name: "max-backoff"},
+		}}
+
+	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
 
 	req := &pb.SubscribeRequest{
 		Request: &pb.SubscribeRequest_Subscribe{
 			Subscribe: &pb.SubscriptionList{
-				Prefix:       pathPrefix,
-				Mode:         pb.SubscriptionList_ONCE,
-				UpdatesOnly:  updatesOnly,
-				Subscription: subscribptions,
+				Mode: pb.SubscriptionList_STREAM,
+				Subscription: []*pb.Subscription{&pb.Subscription{
+					Mode: pb.SubscriptionMode_ON_CHANGE,
+					Path: pathSystemConfig,
+				}},
 			},
 		},
 	}
+	msgQ := coalesce.NewQueue()
+	defer msgQ.Close()
+	c := &streamClient{sr: req, msgQ: msgQ}
+	cs := s.doOnChangeSubscription(c, req.GetSubscribe().GetSubscription())
+	defer s.removeChangeSubscriber(cs)
+
+	nextNotification := func() *pb.Notification {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		msg, _, err := msgQ.Next(ctx)
+		if err != nil {
+			t.Fatalf("error getting message from the queue: %v", err)
+		}
+		n, ok := msg.(*pb.Notification)
+		if !ok || n == nil {
+			t.Fatalf("wanted Notification message in queue, got: %v", msg)
+		}
+		return n
+	}
+	checkNotification := func(desc string, got, want *pb.Notification) {
+		if diff := cmp.Diff(want, got, protocmp.Transform(),
+			protocmp.SortRepeated(updateLess),
+			protocmp.IgnoreFields(&pb.Notification{}, "timestamp")); diff != "" {
+			t.Errorf("%s: Notification diff (-want +got):\n%v", desc, diff)
+		}
+	}
+
+	checkNotification("initial updates", nextNotification(), &pb.Notification{
+		Update: []*pb.Update{
+			&pb.Update{Path: pathHostname, Val: &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_a"}}},
+			&pb.Update{Path: pathLoginBanner, Val: &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "Hello!"}}},
+		}})
+	if msg, _, err := msgQ.Next(context.Background()); err != nil {
+		t.Fatalf("error getting sync_response from the queue: %v", err)
+	} else if _, ok := msg.(subscribeSyncToken); !ok {
+		t.Fatalf("did not receive sync_response message, got: %v", msg)
+	}
+
+	// A change outside of the subscribed path is not sent.
+	if _, err := s.Set(nil, &pb.SetRequest{
+		Update: []*pb.Update{{Path: pathMaxBackoff, Val: &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: 20}}}},
+	}); err != nil {
+		t.Fatalf("error in setting max-backoff: %v", err)
+	}
+	if msgQ.Len() != 0 {
+		t.Errorf("got %d messages in the queue after an unrelated change, want 0", msgQ.Len())
+	}
+
+	if _, err := s.Set(nil, &pb.SetRequest{
+		Update: []*pb.Update{{Path: pathHostname, Val: &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_b"}}}},
+	}); err != nil {
+		t.Fatalf("error in setting hostname: %v", err)
+	}
+	checkNotification("updated leaf", nextNotification(), &pb.Notification{
+		Update: []*pb.Update{
+			&pb.Update{Path: pathHostname, Val: &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_b"}}},
+		}})
+
+	if _, err := s.Set(nil, &pb.SetRequest{Delete: []*pb.Path{pathLoginBanner}}); err != nil {
+		t.Fatalf("error in deleting login-banner: %v", err)
+	}
+	checkNotification("deleted leaf", nextNotification(), &pb.Notification{
+		Delete: []*pb.Path{pathLoginBanner},
+	})
+
+	if err := s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		config.(*gostruct.Device).System.Config.Hostname = ygot.String("switch_c")
+		return nil
+	}); err != nil {
+		t.Fatalf("error in internal update: %v", err)
+	}
+	checkNotification("internal update", nextNotification(), &pb.Notification{
+		Update: []*pb.Update{
+			&pb.Update{Path: pathHostname, Val: &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_c"}}},
+		}})
+}
+
+func TestSubscribeOnChangePrefix(t *testing.T) {
+	s, err := NewServer(model, nil, nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	// decode round-trips m through the wire format, whose decoded repeated
+	// fields have room left for more elements.
+	decode := func(m, out proto.Message) {
+		b, err := proto.Marshal(m)
+		if err != nil {
+			t.Fatalf("error in marshaling %v: %v", m, err)
+		}
+		if err := proto.Unmarshal(b, out); err != nil {
+			t.Fatalf("error in unmarshaling %v: %v", m, err)
+		}
+	}
+	req := &pb.SubscribeRequest{}
+	decode(&pb.SubscribeRequest{
+		Request: &pb.SubscribeRequest_Subscribe{
+			Subscribe: &pb.SubscriptionList{
+				Prefix: mustPath("/components/component[name=a]/state"),
+				Mode:   pb.SubscriptionList_STREAM,
+				Subscription: []*pb.Subscription{
+					{Mode: pb.SubscriptionMode_ON_CHANGE, Path: mustPath("/mfg-name")},
+					{Mode: pb.SubscriptionMode_ON_CHANGE, Path: mustPath("/description")},
+				},
+			},
+		},
+	}, req)
 
-	errC := make(chan error)
-	defer close(errC)
 	msgQ := coalesce.NewQueue()
-	c := &streamClient{sr: req, stream: nil, errC: errC, msgQ: msgQ}
+	defer msgQ.Close()
+	c := &streamClient{sr: req, msgQ: msgQ}
+	cs := s.doOnChangeSubscription(c, req.GetSubscribe().GetSubscription())
+	defer s.removeChangeSubscriber(cs)
+	nextMessage := func() interface{} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		msg, _, err := msgQ.Next(ctx)
+		if err != nil {
+			t.Fatalf("error getting message from the queue: %v", err)
+		}
+		return msg
+	}
+	for {
+		if _, ok := nextMessage().(subscribeSyncToken); ok {
+			break
+		}
+	}
 
-	go s.doOnceSubscription(c)
-	go func() {
-		for {
-			time.Sleep(time.Second)
-			if msgQ.IsClosed() {
-				errC <- nil
-				return
+	stringUpdate := func(path, val string) *pb.Update {
+		return &pb.Update{Path: mustPath(path), Val: &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: val}}}
+	}
+	n := &pb.Notification{}
+	decode(&pb.Notification{
+		Prefix: mustPath("/components/component[name=a]/state"),
+		Update: []*pb.Update{
+			stringUpdate("/mfg-name", "acme"),
+			stringUpdate("/description", "linecard"),
+		},
+	}, n)
+	if err := s.UpdateState(n); err != nil {
+		t.Fatalf("error in updating state: %v", err)
+	}
+
+	got, ok := nextMessage().(*pb.Notification)
+	if !ok {
+		t.Fatalf("wanted Notification message in queue, got: %v", got)
+	}
+	want := &pb.Notification{
+		Update: []*pb.Update{
+			stringUpdate("/components/component[name=a]/state/description", "linecard"),
+			stringUpdate("/components/component[name=a]/state/mfg-name", "acme"),
+		},
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform(),
+		protocmp.SortRepeated(updateLess),
+		protocmp.IgnoreFields(&pb.Notification{}, "timestamp")); diff != "" {
+		t.Errorf("Notification diff (-want +got):\n%v", diff)
+	}
+}
+
+func TestSubscribePoll(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-system:system": {
+			"config": {
+				"hostname": "switch_a"
+			}
+		}
+	}`
+	pathHostname := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "system"},
+			&pb.PathElem{Name: "config"},
+			&pb.PathElem{Name: "hostname"},
+		}}
+	hostnameUpdate := func(hostname string) []*pb.Update {
+		return []*pb.Update{&pb.Update{
+			Path: pathHostname,
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: hostname}},
+		}}
+	}
+	subscribeRequest := func(mode pb.SubscriptionList_Mode) *pb.SubscribeRequest {
+		return &pb.SubscribeRequest{
+			Request: &pb.SubscribeRequest_Subscribe{
+				Subscribe: &pb.SubscriptionList{
+					Mode:         mode,
+					Subscription: []*pb.Subscription{&pb.Subscription{Path: pathHostname}},
+				},
+			},
+		}
+	}
+	pollRequest := &pb.SubscribeRequest{Request: &pb.SubscribeRequest_Poll{Poll: &pb.Poll{}}}
+
+	t.Run("Poll snapshots", func(t *testing.T) {
+		s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+		if err != nil {
+			t.Fatalf("error in creating server: %v", err)
+		}
+		stream := newFakeSubscribeServer()
+		defer stream.cancel()
+		errC := make(chan error, 1)
+		go func() { errC <- s.Subscribe(stream) }()
+
+		stream.reqC <- subscribeRequest(pb.SubscriptionList_POLL)
+		stream.checkResponses(t, hostnameUpdate("switch_a"))
+
+		stream.reqC <- pollRequest
+		stream.checkResponses(t, hostnameUpdate("switch_a"))
+
+		if _, err := s.Set(nil, &pb.SetRequest{Update: hostnameUpdate("switch_b")}); err != nil {
+			t.Fatalf("error in setting hostname: %v", err)
+		}
+		stream.reqC <- pollRequest
+		stream.checkResponses(t, hostnameUpdate("switch_b"))
+
+		stream.cancel()
+		if err := <-errC; !errors.Is(err, context.Canceled) && status.Code(err) != codes.Canceled {
+			t.Errorf("got error %v when the client goes away, want %v", err, codes.Canceled)
+		}
+	})
+
+	t.Run("Poll on a STREAM subscription", func(t *testing.T) {
+		s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+		if err != nil {
+			t.Fatalf("error in creating server: %v", err)
+		}
+		stream := newFakeSubscribeServer()
+		defer stream.cancel()
+		errC := make(chan error, 1)
+		go func() { errC <- s.Subscribe(stream) }()
+
+		req := subscribeRequest(pb.SubscriptionList_STREAM)
+		req.GetSubscribe().GetSubscription()[0].Mode = pb.SubscriptionMode_ON_CHANGE
+		stream.reqC <- req
+		stream.checkResponses(t, hostnameUpdate("switch_a"))
+
+		stream.reqC <- pollRequest
+		if err := <-errC; status.Code(err) != codes.InvalidArgument {
+			t.Errorf("got error %v for a Poll on a STREAM subscription, want %v", err, codes.InvalidArgument)
+		}
+	})
+}
+
+func TestTargetDefinedMode(t *testing.T) {
+	pathInterface := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "interfaces"},
+			&pb.PathElem{Name: "interface", Key: map[string]string{"name": "eth0"}},
+		}}
+	leafPath := func(names ...string) *pb.Path {
+		p := proto.Clone(pathInterface).(*pb.Path)
+		for _, name := range names {
+			p.Elem = append(p.Elem, &pb.PathElem{Name: name})
+		}
+		return p
+	}
+
+	tests := []struct {
+		desc     string
+		opts     []ServerOpt
+		path     *pb.Path
+		wantMode pb.SubscriptionMode
+	}{{
+		desc:     "config leaf",
+		path:     leafPath("config", "mtu"),
+		wantMode: pb.SubscriptionMode_ON_CHANGE,
+	}, {
+		desc:     "enumerated state leaf",
+		path:     leafPath("state", "oper-status"),
+		wantMode: pb.SubscriptionMode_ON_CHANGE,
+	}, {
+		desc:     "counter",
+		path:     leafPath("state", "counters", "in-octets"),
+		wantMode: pb.SubscriptionMode_SAMPLE,
+	}, {
+		desc:     "other state leaf",
+		path:     leafPath("state", "mtu"),
+		wantMode: pb.SubscriptionMode_SAMPLE,
+	}, {
+		desc: "custom rules",
+		opts: []ServerOpt{WithTargetDefinedRules([]TargetDefinedRule{
+			{Match: MatchSchemaPath("/interfaces/interface/state"), Mode: pb.SubscriptionMode_ON_CHANGE},
+		})},
+		path:     leafPath("state", "counters", "in-octets"),
+		wantMode: pb.SubscriptionMode_ON_CHANGE,
+	}, {
+		desc: "no matching custom rule",
+		opts: []ServerOpt{WithTargetDefinedRules([]TargetDefinedRule{
+			{Match: MatchSchemaPath("/interfaces/interface/state"), Mode: pb.SubscriptionMode_ON_CHANGE},
+		})},
+		path:     leafPath("config", "mtu"),
+		wantMode: pb.SubscriptionMode_SAMPLE,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			s, err := NewServer(model, nil, nil, test.opts...)
+			if err != nil {
+				t.Fatalf("error in creating server: %v", err)
+			}
+			if got := s.targetDefinedMode(test.path); got != test.wantMode {
+				t.Errorf("got mode %v for path %v, want %v", got, test.path, test.wantMode)
 			}
+		})
+	}
+}
+
+func TestSubscribeTargetDefined(t *testing.T) {
+	jsonConfigRoot := `{
+		"openconfig-interfaces:interfaces": {
+			"interface": [
+				{
+					"name": "eth0",
+					"config": {
+						"name": "eth0",
+						"mtu": 1500
+					},
+					"state": {
+						"oper-status": "UP",
+						"counters": {
+							"in-octets": "100"
+						}
+					}
+				}
+			]
 		}
-	}()
+	}`
+	pathInterface := &pb.Path{
+		Elem: []*pb.PathElem{
+			&pb.PathElem{Name: "interfaces"},
+			&pb.PathElem{Name: "interface", Key: map[string]string{"name": "eth0"}},
+		}}
+	pathOperStatus := proto.Clone(pathInterface).(*pb.Path)
+	pathOperStatus.Elem = append(pathOperStatus.Elem, &pb.PathElem{Name: "state"}, &pb.PathElem{Name: "oper-status"})
 
-	gotErr := <-errC
-	if gotErr != nil && wantError == nil {
-		t.Fatalf("got a error, wanted nil: %v", gotErr)
+	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
 	}
-	if wantError != nil {
-		if gotErr == nil {
-			t.Fatalf("wanted an error, got nil: %v", wantError)
-		}
-		if errors.Is(gotErr, wantError) {
-			t.Fatalf("wanted error: %v, got error: %v", wantError, gotErr)
-		}
-		return
+	stream := newFakeSubscribeServer()
+	defer stream.cancel()
+	go s.Subscribe(stream)
+
+	stream.reqC <- &pb.SubscribeRequest{
+		Request: &pb.SubscribeRequest_Subscribe{
+			Subscribe: &pb.SubscriptionList{
+				Mode: pb.SubscriptionList_STREAM,
+				Subscription: []*pb.Subscription{&pb.Subscription{
+					Mode:           pb.SubscriptionMode_TARGET_DEFINED,
+					SampleInterval: uint64(maxStreamSampleInterval.Nanoseconds()),
+					Path:           pathInterface,
+				}},
+			},
+		},
 	}
 
-	// Check response value
-	gotSync := false
-	var gotNotifications []*pb.Notification
-	for {
-		msg, _, err := c.msgQ.Next(context.Background())
-		if err != nil {
-			if coalesce.IsClosedQueue(err) {
+	// Each leaf is sent once before the sync_response, either by the on change
+	// or by the sample part of the subscription.
+	gotLeaves := map[string]int{}
+	for synced := false; !synced; {
+		select {
+		case resp := <-stream.respC:
+			if resp.GetSyncResponse() {
+				synced = true
 				break
-			} else {
-				t.Fatalf("Error getting message from the queue: %v", err)
 			}
+			for _, u := range resp.GetUpdate().GetUpdate() {
+				p, err := ygot.PathToString(u.GetPath())
+				if err != nil {
+					t.Fatalf("invalid path %v: %v", u.GetPath(), err)
+				}
+				gotLeaves[p]++
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for the sync_response")
 		}
-
-		if _, ok := msg.(subscribeSyncToken); ok {
-			gotSync = true
-			continue
-		}
-
-		n, ok := msg.(*pb.Notification)
-		if !ok || n == nil {
-			t.Fatalf("invalid message in queue: %v", msg)
-		}
-		gotNotifications = append(gotNotifications, n)
 	}
-	if diff := cmp.Diff(gotNotifications, wantNotifications, protocmp.Transform(),
-		protocmp.SortRepeated(updateLess),
-		protocmp.IgnoreFields(&pb.Notification{}, "timestamp")); diff != "" {
-		t.Errorf("Updates diff:\n%v", diff)
+	wantLeaves := map[string]int{
+		"/interfaces/interface[name=eth0]/name":                     1,
+		"/interfaces/interface[name=eth0]/config/name":              1,
+		"/interfaces/interface[name=eth0]/config/mtu":               1,
+		"/interfaces/interface[name=eth0]/state/oper-status":        1,
+		"/interfaces/interface[name=eth0]/state/counters/in-octets": 1,
 	}
-	if !gotSync {
-		t.Errorf("did not receive sync_response message")
+	if diff := cmp.Diff(wantLeaves, gotLeaves); diff != "" {
+		t.Errorf("initial leaves diff (-want +got):\n%v", diff)
+	}
+
+	// Changes of the counters are only sampled, oper-status is sent on change.
+	if err := s.InternalUpdate(func(config ygot.ValidatedGoStruct) error {
+		intf := config.(*gostruct.Device).Interfaces.Interface["eth0"]
+		intf.State.Counters.InOctets = ygot.Uint64(200)
+		intf.State.OperStatus = gostruct.OpenconfigInterfaces_Interfaces_Interface_State_OperStatus_DOWN
+		return nil
+	}); err != nil {
+		t.Fatalf("error in internal update: %v", err)
+	}
+	select {
+	case resp := <-stream.respC:
+		want := []*pb.Update{&pb.Update{
+			Path: pathOperStatus,
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "DOWN"}},
+		}}
+		if diff := cmp.Diff(want, resp.GetUpdate().GetUpdate(), protocmp.Transform()); diff != "" {
+			t.Errorf("on change Updates diff (-want +got):\n%v", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for the oper-status change")
 	}
 }
 
-func TestSubscribeSample(t *testing.T) {
+func TestSubscribeUseModels(t *testing.T) {
 	jsonConfigRoot := `{
 		"openconfig-system:system": {
+			"config": {
+				"hostname": "switch_a"
+			},
 			"openconfig-openflow:openflow": {
 				"agent": {
-					"state": {
-						"failure-mode": "SECURE",
+					"config": {
 						"max-backoff": 10
 					}
 				}
 			}
 		}
-}`
-	pathAgentState := &pb.Path{
-		Elem: []*pb.PathElem{
-			&pb.PathElem{Name: "system"},
-			&pb.PathElem{Name: "openflow"},
-			&pb.PathElem{Name: "agent"},
-			&pb.PathElem{Name: "state"},
-		}}
-	pathAgentFailureMode := proto.Clone(pathAgentState).(*pb.Path)
-	pathAgentFailureMode.Elem = append(pathAgentFailureMode.Elem, &pb.PathElem{Name: "failure-mode"})
-	pathAgentMaxBackoff := proto.Clone(pathAgentState).(*pb.Path)
-	pathAgentMaxBackoff.Elem = append(pathAgentMaxBackoff.Elem, &pb.PathElem{Name: "max-backoff"})
-	pathAgentFoo := proto.Clone(pathAgentState).(*pb.Path)
-	pathAgentFoo.Elem = append(pathAgentMaxBackoff.Elem, &pb.PathElem{Name: "foo"})
-
-	secsToNanoSecs := func(s int) uint64 { return uint64(s * 1000000000) }
-
-	s, err := NewServer(model, []byte(jsonConfigRoot), nil)
-	if err != nil {
-		t.Fatalf("error in creating server: %v", err)
-	}
+	}`
+	pathSystem := &pb.Path{Elem: []*pb.PathElem{&pb.PathElem{Name: "system"}}}
 
 	tests := []struct {
-		desc              string
-		pathPrefix        *pb.Path
-		subscription      *pb.Subscription
-		timeout           time.Duration
-		updatesOnly       bool
-		wantUpdates       []*pb.Update
-		wantNotifications int
+		desc        string
+		useModels   []*pb.ModelData
+		wantErrCode codes.Code
+		wantUpdates []*pb.Update
 	}{{
-		desc: "Single Sample Subscription",
-		subscription: &pb.Subscription{
-			Mode:           pb.SubscriptionMode_SAMPLE,
-			SampleInterval: secsToNanoSecs(1),
-			Path:           pathAgentFailureMode},
-		timeout:           time.Millisecond * 3500,
-		wantNotifications: 4,
-		wantUpdates: []*pb.Update{
-			&pb.Update{
-				Path: pathAgentFailureMode,
-				Val: &pb.TypedValue{
-					Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
-		},
+		desc:      "model of the subscribed node",
+		useModels: []*pb.ModelData{{Name: modeldata.OpenconfigSystemModel}},
+		wantUpdates: []*pb.Update{&pb.Update{
+			Path: &pb.Path{Elem: []*pb.PathElem{{Name: "system"}, {Name: "config"}, {Name: "hostname"}}},
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: "switch_a"}},
+		}},
 	}, {
-		desc: "Single Subscribe to Update Only with 0 interval",
-		subscription: &pb.Subscription{
-			Mode:           pb.SubscriptionMode_SAMPLE,
-			SampleInterval: 0,
-			Path:           pathAgentState},
-		timeout:           time.Millisecond * 3500,
-		wantNotifications: 3,
-		updatesOnly:       true,
-		wantUpdates: []*pb.Update{
-			&pb.Update{
-				Path: pathAgentFailureMode,
-				Val: &pb.TypedValue{
-					Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
-			&pb.Update{
-				Path: pathAgentMaxBackoff,
-				Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(10)}}},
-		},
+		desc:      "augmenting model",
+		useModels: []*pb.ModelData{{Name: modeldata.OpenconfigOpenflowModel}},
+		wantUpdates: []*pb.Update{&pb.Update{
+			Path: &pb.Path{Elem: []*pb.PathElem{{Name: "system"}, {Name: "openflow"}, {Name: "agent"}, {Name: "config"}, {Name: "max-backoff"}}},
+			Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: 10}},
+		}},
 	}, {
-		desc:       "Subscribe with prefix",
-		pathPrefix: pathAgentState,
-		subscription: &pb.Subscription{
-			Mode:           pb.SubscriptionMode_SAMPLE,
-			SampleInterval: secsToNanoSecs(2),
-			Path:           &pb.Path{Elem: []*pb.PathElem{&pb.PathElem{Name: "failure-mode"}}}},
-		timeout:           time.Millisecond * 4500,
-		wantNotifications: 3,
-		wantUpdates: []*pb.Update{
-			&pb.Update{
-				Path: pathAgentFailureMode,
-				Val: &pb.TypedValue{
-					Value: &pb.TypedValue_StringVal{StringVal: "SECURE"}}},
-		},
+		desc:        "unknown model",
+		useModels:   []*pb.ModelData{{Name: "foo"}},
+		wantErrCode: codes.InvalidArgument,
 	}}
 
 	for _, test := range tests {
 		t.Run(test.desc, func(t *testing.T) {
-			runTestSubscribeSample(t, s, test.subscription, test.pathPrefix, test.updatesOnly, test.timeout, test.wantNotifications, test.wantUpdates)
+			s, err := NewServer(model, []byte(jsonConfigRoot), nil)
+			if err != nil {
+				t.Fatalf("error in creating server: %v", err)
+			}
+			stream := newFakeSubscribeServer()
+			defer stream.cancel()
+			errC := make(chan error, 1)
+			go func() { errC <- s.Subscribe(stream) }()
+
+			stream.reqC <- &pb.SubscribeRequest{
+				Request: &pb.SubscribeRequest_Subscribe{
+					Subscribe: &pb.SubscriptionList{
+						Mode:         pb.SubscriptionList_ONCE,
+						UseModels:    test.useModels,
+						Subscription: []*pb.Subscription{&pb.Subscription{Path: pathSystem}},
+					},
+				},
+			}
+			if test.wantErrCode != codes.OK {
+				if err := <-errC; status.Code(err) != test.wantErrCode {
+					t.Fatalf("got error %v, want %v", err, test.wantErrCode)
+				}
+				return
+			}
+			stream.checkResponses(t, test.wantUpdates)
 		})
 	}
 }
 
-// runTestSubscribeSample requests STREAM sampling subscription, and compares the returned Notifications.
-func runTestSubscribeSample(t *testing.T, s *Server, subscription *pb.Subscription, pathPrefix *pb.Path, updatesOnly bool, timeout time.Duration, wantNotifications int, wantUpdates []*pb.Update,
-) {
-	req := &pb.SubscribeRequest{
-		Request: &pb.SubscribeRequest_Subscribe{
-			Subscribe: &pb.SubscriptionList{
-				Prefix:       pathPrefix,
-				Mode:         pb.SubscriptionList_STREAM,
-				UpdatesOnly:  updatesOnly,
-				Subscription: []*pb.Subscription{subscription},
-			},
-		},
-	}
+// fakeSubscribeServer is a pb.GNMI_SubscribeServer reading the client requests
+// from reqC and writing the responses to respC.
+type fakeSubscribeServer struct {
+	pb.GNMI_SubscribeServer
+	ctx    context.Context
+	cancel context.CancelFunc
+	reqC   chan *pb.SubscribeRequest
+	respC  chan *pb.SubscribeResponse
+}
 
-	sampleInterval := subscription.GetSampleInterval()
-	if sampleInterval == 0 {
-		sampleInterval = uint64(minStreamSampleInterval.Nanoseconds())
+func newFakeSubscribeServer() *fakeSubscribeServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &fakeSubscribeServer{
+		ctx:    ctx,
+		cancel: cancel,
+		reqC:   make(chan *pb.SubscribeRequest),
+		respC:  make(chan *pb.SubscribeResponse, 10),
 	}
-	interval := time.Nanosecond * time.Duration(sampleInterval)
-
-	errC := make(chan error)
-	doneC := make(chan bool)
-	defer close(errC)
-	msgQ := coalesce.NewQueue()
-	c := &streamClient{sr: req, stream: nil, errC: errC, msgQ: msgQ}
-
-	go s.doSampleSubscription(c, subscription, doneC)
-
-	time.Sleep(timeout)
-	close(doneC)
-	msgQ.Close()
+}
 
-	gotNotifications := 0
-	lastNotificationTimestamp := int64(time.Now().UnixNano())
+func (f *fakeSubscribeServer) Context() context.Context {
+	return f.ctx
+}
 
-	if !updatesOnly && wantNotifications > 0 {
-		msg, _, err := c.msgQ.Next(context.Background())
-		if err != nil {
-			t.Fatalf("Error getting initial Notification from the queue: %v", err)
-		}
-		n, ok := msg.(*pb.Notification)
-		if !ok || n == nil {
-			t.Fatalf("wanted Notification message in queue, got: %v", msg)
-		}
-		lastNotificationTimestamp = n.GetTimestamp()
-		gotNotifications = gotNotifications + 1
-		if diff := cmp.Diff(n.GetUpdate(), wantUpdates, protocmp.Transform(), protocmp.SortRepeated(updateLess)); diff != "" {
-			t.Errorf("Initial Notification Updates diff:\n%v", diff)
-		}
+func (f *fakeSubscribeServer) Send(resp *pb.SubscribeResponse) error {
+	select {
+	case f.respC <- resp:
+		return nil
+	case <-f.ctx.Done():
+		return f.ctx.Err()
 	}
+}
 
-	msg, _, err := c.msgQ.Next(context.Background())
-	if err != nil {
-		t.Fatalf("Error getting sync_response from the queue: %v", err)
-	}
-	if _, ok := msg.(subscribeSyncToken); !ok {
-		t.Fatalf("did not receive sync_response message")
+func (f *fakeSubscribeServer) Recv() (*pb.SubscribeRequest, error) {
+	select {
+	case req := <-f.reqC:
+		return req, nil
+	case <-f.ctx.Done():
+		return nil, status.FromContextError(f.ctx.Err()).Err()
 	}
+}
 
-	for {
-		msg, _, err := c.msgQ.Next(context.Background())
-		if err != nil {
-			if coalesce.IsClosedQueue(err) {
-				break
-			} else {
-				t.Fatalf("Error getting Notifications from the queue: %v", err)
-			}
-		}
-
-		if _, ok := msg.(subscribeSyncToken); ok {
-			t.Fatalf("received unexpected sync_response message")
-		}
-
-		n, ok := msg.(*pb.Notification)
-		if !ok || n == nil {
-			t.Fatalf("wanted Notification message in queue, got: %v", msg)
-		}
-		gotNotifications = gotNotifications + 1
-		timeDiff := time.Nanosecond * time.Duration(n.GetTimestamp()-lastNotificationTimestamp)
-		lastNotificationTimestamp = n.GetTimestamp()
-		if (timeDiff - interval) > interval {
-			t.Errorf("Notification messages not within sampling interval: %v", timeDiff)
-		}
-		if diff := cmp.Diff(n.GetUpdate(), wantUpdates, protocmp.Transform(), cmpopts.SortSlices(updateLess)); diff != "" {
-			t.Errorf("Notification Updates diff:\n%v", diff)
+// checkResponses reads a Notification and a sync_response from the stream,
+// and compares the Updates of the Notification with wantUpdates.
+func (f *fakeSubscribeServer) checkResponses(t *testing.T, wantUpdates []*pb.Update) {
+	t.Helper()
+	var resps []*pb.SubscribeResponse
+	for len(resps) < 2 {
+		select {
+		case resp := <-f.respC:
+			resps = append(resps, resp)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for responses, got %v", resps)
 		}
 	}
-
-	if diff := cmp.Diff(wantNotifications, gotNotifications); diff != "" {
-		t.Errorf("wanted %d Notifications, got %d", wantNotifications, gotNotifications)
+	if diff := cmp.Diff(wantUpdates, resps[0].GetUpdate().GetUpdate(), protocmp.Transform(), protocmp.SortRepeated(updateLess)); diff != "" {
+		t.Errorf("Updates diff (-want +got):\n%v", diff)
+	}
+	if !resps[1].GetSyncResponse() {
+		t.Errorf("got %v, want a sync_response", resps[1])
 	}
-
 }
 
 // updateLess compares 2 Update messages by the string comparison of their Paths.
@@ -1696,3 +4778,59 @@ func updateLess(a, b *pb.Update) bool {
 	}
 	return pathA < pathB
 }
+
+// mustPath parses the string path into a structured gNMI Path, as decoded from
+// the wire, and panics on error.
+func mustPath(s string) *pb.Path {
+	p, err := ygot.StringToStructuredPath(s)
+	if err != nil {
+		panic(err)
+	}
+	for _, elem := range p.GetElem() {
+		if len(elem.GetKey()) == 0 {
+			elem.Key = nil
+		}
+	}
+	return p
+}
+
+// benchmarkConfig returns the IETF JSON config of n interfaces.
+func benchmarkConfig(n int) []byte {
+	interfaces := make([]interface{}, n)
+	for i := range interfaces {
+		name := fmt.Sprintf("eth%d", i)
+		interfaces[i] = map[string]interface{}{
+			"name":   name,
+			"config": map[string]interface{}{"name": name, "mtu": 1500, "description": "uplink"},
+		}
+	}
+	config, err := json.Marshal(map[string]interface{}{
+		"openconfig-interfaces:interfaces": map[string]interface{}{"interface": interfaces},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return config
+}
+
+func BenchmarkSet(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		config := benchmarkConfig(n)
+		b.Run(fmt.Sprintf("%d interfaces", n), func(b *testing.B) {
+			s, err := NewServer(model, config, nil)
+			if err != nil {
+				b.Fatalf("error in creating server: %v", err)
+			}
+			path := mustPath("/interfaces/interface[name=eth0]/config/mtu")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Set(nil, &pb.SetRequest{Update: []*pb.Update{{
+					Path: path,
+					Val:  &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(1500 + i%2)}},
+				}}}); err != nil {
+					b.Fatalf("error in setting mtu: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/openconfig/ygot/ygot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	extpb "github.com/openconfig/gnmi/proto/gnmi_ext"
+)
+
+// snapshot is the data read by Get and Subscribe: the last committed config
+// merged with the state tree, the config revisions and the election IDs of
+// the primaries. A published snapshot is never modified, so the readers use it
+// without holding s.mu, and never wait for a writer, such as a Set applying
+// its config to the device.
+type snapshot struct {
+	tree        ygot.ValidatedGoStruct
+	history     []*revision
+	electionIDs map[string]*extpb.Uint128
+}
+
+// snapshot returns the last published snapshot.
+func (s *Server) snapshot() *snapshot {
+	return s.snap.Load().(*snapshot)
+}
+
+// publishSnapshot publishes the current data as the snapshot of the readers.
+// The writers replace the config and the state tree rather than modify them,
+// so the snapshot shares them. A writer publishes the snapshot before the
+// changes it holds, so that ON_CHANGE subscribers get the changes missing
+// from the snapshot they started with. The caller must hold s.mu.
+func (s *Server) publishSnapshot() error {
+	tree, err := s.tree()
+	if err != nil {
+		return err
+	}
+	s.snap.Store(&snapshot{tree: tree, history: s.history, electionIDs: s.electionIDs})
+	return nil
+}
+
+// publishElectionIDs publishes the election IDs of the primaries with the data
+// of the last snapshot. The caller must hold s.mu.
+func (s *Server) publishElectionIDs() {
+	last := s.snapshot()
+	s.snap.Store(&snapshot{tree: last.tree, history: last.history, electionIDs: s.electionIDs})
+}
+
+// copyConfig returns a copy of the config, to be modified then published by a
+// writer. The keys of the lists keyed by a union are shared with the copied
+// entries, as the validation requires. The caller must hold s.mu.
+func (s *Server) copyConfig() (ygot.ValidatedGoStruct, error) {
+	gs, err := ygot.DeepCopy(s.config)
+	if err != nil {
+		return nil, fmt.Errorf("error in copying config struct: %v", err)
+	}
+	shareUnionKeys(reflect.ValueOf(gs))
+	return gs.(ygot.ValidatedGoStruct), nil
+}
+
+// revisionAt returns the revision in effect at the time ts. Return error if the
+// history does not go back to ts.
+func (snap *snapshot) revisionAt(ts int64) (*revision, error) {
+	revs, err := snap.revisionsIn(ts, ts)
+	if err != nil {
+		return nil, err
+	}
+	return revs[0], nil
+}
+
+// revisionsIn returns the revisions in effect from start to end, starting with
+// the revision in effect at start. Return error if the history does not go
+// back to start.
+func (snap *snapshot) revisionsIn(start, end int64) ([]*revision, error) {
+	history := snap.history
+	if len(history) == 0 || history[0].timestamp > start {
+		return nil, status.Errorf(codes.OutOfRange, "no config revision is kept at time %d", start)
+	}
+	first := 0
+	for i, rev := range history {
+		if rev.timestamp > start {
+			break
+		}
+		first = i
+	}
+	last := first
+	for last+1 < len(history) && history[last+1].timestamp <= end {
+		last++
+	}
+	return history[first : last+1], nil
+}
+
+// revisionByID returns the revision of the ID kept in the history.
+func (snap *snapshot) revisionByID(id uint64) (*revision, error) {
+	for _, rev := range snap.history {
+		if rev.id == id {
+			return rev, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "revision %d is not kept in the history", id)
+}
@@ -0,0 +1,164 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"fmt"
+	"reflect"
+
+	log "github.com/golang/glog"
+	"github.com/openconfig/ygot/ygot"
+	"github.com/openconfig/ygot/ytypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// UpdateState applies the operational state updates and deletes of the
+// notifications, as pushed by a device driver, to the state tree of the
+// server. The state tree is kept apart from the config, so Set never changes
+// it, and is merged with the config in the responses to Get and Subscribe.
+// Only read-only nodes of the schema may be updated or deleted. The
+// notifications are validated and applied all together, or not at all, then
+// published to the ON_CHANGE subscribers with their timestamps.
+func (s *Server) UpdateState(notifications ...*pb.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.copyState()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	changes := make([]*pb.Notification, len(notifications))
+	for i, n := range notifications {
+		changes[i] = &pb.Notification{Timestamp: n.GetTimestamp()}
+		for _, path := range n.GetDelete() {
+			fullPath := gnmiFullPath(n.GetPrefix(), path)
+			if grpcStatusError := s.checkStatePath(fullPath); grpcStatusError != nil {
+				return grpcStatusError
+			}
+			if err := ytypes.DeleteNode(s.model.schemaTreeRoot, state, fullPath); err != nil {
+				return status.Errorf(codes.InvalidArgument, "error in deleting state %v: %v", fullPath, err)
+			}
+			changes[i].Delete = append(changes[i].Delete, fullPath)
+		}
+		for _, u := range n.GetUpdate() {
+			fullPath := gnmiFullPath(n.GetPrefix(), u.GetPath())
+			if grpcStatusError := s.checkStatePath(fullPath); grpcStatusError != nil {
+				return grpcStatusError
+			}
+			if err := ytypes.SetNode(s.model.schemaTreeRoot, state, fullPath, u.GetVal(), &ytypes.InitMissingElements{}); err != nil {
+				return status.Errorf(codes.InvalidArgument, "error in updating state %v: %v", fullPath, err)
+			}
+			changes[i].Update = append(changes[i].Update, &pb.Update{Path: fullPath, Val: u.GetVal()})
+		}
+	}
+	if err := s.commitState(state, changes); err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid state: %v", err)
+	}
+	return nil
+}
+
+// copyState returns a copy of the state tree, or a new empty one, to be
+// modified then committed by a writer. The caller must hold s.mu.
+func (s *Server) copyState() (ygot.ValidatedGoStruct, error) {
+	if s.state == nil {
+		return s.model.NewConfigStruct(nil)
+	}
+	gs, err := ygot.DeepCopy(s.state)
+	if err != nil {
+		return nil, fmt.Errorf("error in copying the state tree: %v", err)
+	}
+	return gs.(ygot.ValidatedGoStruct), nil
+}
+
+// commitState validates the modified copy of the state tree, which then
+// replaces the state tree, and publishes the changes. The caller must hold
+// s.mu.
+func (s *Server) commitState(state ygot.ValidatedGoStruct, changes []*pb.Notification) error {
+	shareUnionKeys(reflect.ValueOf(state))
+	// The list entries of the state tree miss the config their keys refer to.
+	if err := state.Validate(&ytypes.LeafrefOptions{IgnoreMissingData: true}); err != nil {
+		return err
+	}
+	s.state = state
+	if err := s.publishSnapshot(); err != nil {
+		log.Errorf("error in publishing the config snapshot: %v", err)
+	}
+	for _, n := range changes {
+		s.publishChanges(n)
+	}
+	return nil
+}
+
+// shareUnionKeys sets the key fields of the entries of the lists keyed by a
+// union, below the struct pointed by v, to their map keys. The validation
+// requires them to be the same pointer, which DeepCopy and SetNode do not
+// keep.
+func shareUnionKeys(v reflect.Value) {
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Ptr:
+			shareUnionKeys(f)
+		case reflect.Map:
+			iter := f.MapRange()
+			for iter.Next() {
+				key, entry := iter.Key(), iter.Value()
+				if key.Kind() == reflect.Interface && entry.Kind() == reflect.Ptr && !entry.IsNil() {
+					e := entry.Elem()
+					for j := 0; j < e.NumField(); j++ {
+						if kf := e.Field(j); kf.Type() == key.Type() && reflect.DeepEqual(kf.Interface(), key.Interface()) {
+							kf.Set(key)
+						}
+					}
+				}
+				shareUnionKeys(entry)
+			}
+		}
+	}
+}
+
+// checkStatePath returns an InvalidArgument error unless the path is a
+// read-only node of the schema.
+func (s *Server) checkStatePath(path *pb.Path) error {
+	e := s.model.schemaEntry(path)
+	switch {
+	case len(path.GetElem()) == 0 || e == nil:
+		return status.Errorf(codes.InvalidArgument, "path %v not found in the schema", path)
+	case !e.ReadOnly():
+		return status.Errorf(codes.InvalidArgument, "path %v is config, not state", path)
+	}
+	return nil
+}
+
+// tree returns the data tree served to Get and Subscribe: the config merged
+// with the state tree, if any. The caller must hold s.mu.
+func (s *Server) tree() (ygot.ValidatedGoStruct, error) {
+	if s.state == nil {
+		return s.config, nil
+	}
+	merged, err := ygot.MergeStructs(s.config, s.state, &ygot.MergeOverwriteExistingFields{})
+	if err != nil {
+		return nil, fmt.Errorf("error in merging the state tree: %v", err)
+	}
+	return merged.(ygot.ValidatedGoStruct), nil
+}
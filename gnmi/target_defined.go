@@ -0,0 +1,116 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"strings"
+
+	"github.com/openconfig/goyang/pkg/yang"
+	"github.com/openconfig/ygot/util"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// TargetDefinedRule selects the mode used to stream the leaves matched by a
+// TARGET_DEFINED subscription.
+type TargetDefinedRule struct {
+	// Match returns true if the rule applies to the leaf schema node.
+	Match func(*yang.Entry) bool
+	// Mode is the subscription mode of the matching leaves, either ON_CHANGE
+	// or SAMPLE.
+	Mode pb.SubscriptionMode
+}
+
+// DefaultTargetDefinedRules samples the counters, streams the config leaves
+// and the enumerated state leaves, such as oper-status, on change, and samples
+// everything else.
+var DefaultTargetDefinedRules = []TargetDefinedRule{
+	{Match: MatchSchemaPath("/.../counters"), Mode: pb.SubscriptionMode_SAMPLE},
+	{Match: MatchConfig, Mode: pb.SubscriptionMode_ON_CHANGE},
+	{Match: MatchEnumerated, Mode: pb.SubscriptionMode_ON_CHANGE},
+}
+
+// WithTargetDefinedRules replaces DefaultTargetDefinedRules as the rules of
+// the Server. The first matching rule gives the mode of a leaf, and the leaves
+// matching no rule are sampled.
+func WithTargetDefinedRules(rules []TargetDefinedRule) ServerOpt {
+	return func(s *Server) {
+		s.targetDefinedRules = rules
+	}
+}
+
+// MatchConfig matches the config leaves.
+func MatchConfig(e *yang.Entry) bool {
+	return util.IsConfig(e)
+}
+
+// MatchEnumerated matches the enumeration and identityref leaves.
+func MatchEnumerated(e *yang.Entry) bool {
+	return util.IsEnumeratedType(e.Type)
+}
+
+// MatchSchemaPath returns a match function for the leaves at or below the
+// schema path, such as "/interfaces/interface/state/counters". The path may use
+// "*" to match any node name and "..." to match any number of nodes.
+func MatchSchemaPath(path string) func(*yang.Entry) bool {
+	var pattern []*pb.PathElem
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name != "" {
+			pattern = append(pattern, &pb.PathElem{Name: name})
+		}
+	}
+	return func(e *yang.Entry) bool {
+		var elems []*pb.PathElem
+		for _, name := range strings.Split(strings.Trim(util.SchemaTreePathNoModule(e), "/"), "/") {
+			elems = append(elems, &pb.PathElem{Name: name})
+		}
+		return pathMatch(pattern, elems)
+	}
+}
+
+// targetDefinedMode returns the mode used to stream the leaf at path in a
+// TARGET_DEFINED subscription.
+func (s *Server) targetDefinedMode(path *pb.Path) pb.SubscriptionMode {
+	e := s.model.schemaEntry(path)
+	if e == nil {
+		return pb.SubscriptionMode_SAMPLE
+	}
+	for _, r := range s.targetDefinedRules {
+		if r.Match(e) {
+			return r.Mode
+		}
+	}
+	return pb.SubscriptionMode_SAMPLE
+}
+
+// targetDefinedFilter returns a filter keeping the leaves streamed with mode
+// in a TARGET_DEFINED subscription.
+func (s *Server) targetDefinedFilter(mode pb.SubscriptionMode) func(*pb.Path) bool {
+	return func(path *pb.Path) bool {
+		return s.targetDefinedMode(path) == mode
+	}
+}
+
+// filterUpdates returns the updates whose path is kept by filter.
+func filterUpdates(updates []*pb.Update, filter func(*pb.Path) bool) []*pb.Update {
+	var kept []*pb.Update
+	for _, u := range updates {
+		if filter(u.GetPath()) {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
@@ -1,102 +1,39 @@
 package gnmi
 
-import (
-	"fmt"
-	"strconv"
-
-	log "github.com/golang/glog"
-	"github.com/openconfig/goyang/pkg/yang"
-
-	pb "github.com/openconfig/gnmi/proto/gnmi"
-)
-
-// getChildNode gets a node's child with corresponding schema specified by path
-// element. If not found and createIfNotExist is set as true, an empty node is
-// created and returned.
-func getChildNode(node map[string]interface{}, schema *yang.Entry, elem *pb.PathElem, createIfNotExist bool) (interface{}, *yang.Entry) {
-	var nextSchema *yang.Entry
-	var ok bool
-
-	if nextSchema, ok = schema.Dir[elem.Name]; !ok {
-		return nil, nil
+import pb "github.com/openconfig/gnmi/proto/gnmi"
+
+// pathMatch returns true if the path elems match the pattern, or lie below a
+// node matching it. The pattern may use "*" as an elem name or a key value to
+// match any name or value, and "..." as an elem name to match any number of
+// elems.
+func pathMatch(pattern, elems []*pb.PathElem) bool {
+	if len(pattern) == 0 {
+		return true
 	}
-
-	var nextNode interface{}
-	if elem.GetKey() == nil {
-		if nextNode, ok = node[elem.Name]; !ok {
-			if createIfNotExist {
-				node[elem.Name] = make(map[string]interface{})
-				nextNode = node[elem.Name]
+	if pattern[0].GetName() == "..." {
+		for i := 0; i <= len(elems); i++ {
+			if pathMatch(pattern[1:], elems[i:]) {
+				return true
 			}
 		}
-		return nextNode, nextSchema
+		return false
 	}
-
-	nextNode = getKeyedListEntry(node, elem, createIfNotExist)
-	return nextNode, nextSchema
-}
-
-// getKeyedListEntry finds the keyed list entry in node by the name and key of
-// path elem. If entry is not found and createIfNotExist is true, an empty entry
-// will be created (the list will be created if necessary).
-func getKeyedListEntry(node map[string]interface{}, elem *pb.PathElem, createIfNotExist bool) map[string]interface{} {
-	curNode, ok := node[elem.Name]
-	if !ok {
-		if !createIfNotExist {
-			return nil
-		}
-
-		// Create a keyed list as node child and initialize an entry.
-		m := make(map[string]interface{})
-		for k, v := range elem.Key {
-			m[k] = v
-			if vAsNum, err := strconv.ParseFloat(v, 64); err == nil {
-				m[k] = vAsNum
-			}
-		}
-		node[elem.Name] = []interface{}{m}
-		return m
+	if len(elems) == 0 || !pathElemMatch(pattern[0], elems[0]) {
+		return false
 	}
+	return pathMatch(pattern[1:], elems[1:])
+}
 
-	// Search entry in keyed list.
-	keyedList, ok := curNode.([]interface{})
-	if !ok {
-		return nil
-	}
-	for _, n := range keyedList {
-		m, ok := n.(map[string]interface{})
-		if !ok {
-			log.Errorf("wrong keyed list entry type: %T", n)
-			return nil
-		}
-		keyMatching := true
-		// must be exactly match
-		for k, v := range elem.Key {
-			attrVal, ok := m[k]
-			if !ok {
-				return nil
-			}
-			if v != fmt.Sprintf("%v", attrVal) {
-				keyMatching = false
-				break
-			}
-		}
-		if keyMatching {
-			return m
-		}
+// pathElemMatch returns true if the path elem matches the pattern elem, where
+// "*" matches any name or key value.
+func pathElemMatch(pattern, elem *pb.PathElem) bool {
+	if pattern.GetName() != "*" && pattern.GetName() != elem.GetName() {
+		return false
 	}
-	if !createIfNotExist {
-		return nil
-	}
-
-	// Create an entry in keyed list.
-	m := make(map[string]interface{})
-	for k, v := range elem.Key {
-		m[k] = v
-		if vAsNum, err := strconv.ParseFloat(v, 64); err == nil {
-			m[k] = vAsNum
+	for k, v := range pattern.GetKey() {
+		if v != "*" && elem.GetKey()[k] != v {
+			return false
 		}
 	}
-	node[elem.Name] = append(keyedList, m)
-	return m
+	return true
 }
@@ -0,0 +1,183 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gnmi
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/openconfig/goyang/pkg/yang"
+	"github.com/openconfig/ygot/util"
+	"github.com/openconfig/ygot/ygot"
+	"github.com/openconfig/ygot/ytypes"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// hasWildcards returns true if the path contains a "*" or "..." element name,
+// or a "*" key value.
+func hasWildcards(path *pb.Path) bool {
+	for _, elem := range path.GetElem() {
+		if elem.GetName() == "*" || elem.GetName() == "..." {
+			return true
+		}
+		for _, v := range elem.GetKey() {
+			if v == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaChildren returns the child schema nodes of e by name, looking through
+// the choice and case nodes.
+func schemaChildren(e *yang.Entry) map[string]*yang.Entry {
+	children := make(map[string]*yang.Entry)
+	for _, ch := range util.FindFirstNonChoiceOrCase(e) {
+		children[ch.Name] = ch
+	}
+	return children
+}
+
+// expandWildcards returns the sorted paths of the data nodes of config
+// matching the path, which may contain wildcards. "*" matches one element of
+// any name or any key value, and "..." matches any number of elements. The
+// matches below another match are dropped, as they are in its subtree.
+func (s *Server) expandWildcards(config ygot.GoStruct, path *pb.Path) ([]*pb.Path, error) {
+	matches := make(map[string]*pb.Path)
+	err := s.expandElems(config, nil, s.model.schemaTreeRoot, path.GetElem(), func(elems []*pb.PathElem) error {
+		p := &pb.Path{Elem: elems}
+		key, err := ygot.PathToString(p)
+		if err != nil {
+			return err
+		}
+		matches[key] = p
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(matches))
+	for k, p := range matches {
+		under, err := underMatch(matches, p)
+		if err != nil {
+			return nil, err
+		}
+		if !under {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	paths := make([]*pb.Path, len(keys))
+	for i, k := range keys {
+		paths[i] = matches[k]
+	}
+	return paths, nil
+}
+
+// underMatch returns true if one of the ancestors of the path is in matches.
+func underMatch(matches map[string]*pb.Path, path *pb.Path) (bool, error) {
+	for i := len(path.GetElem()) - 1; i >= 0; i-- {
+		key, err := ygot.PathToString(&pb.Path{Elem: path.GetElem()[:i]})
+		if err != nil {
+			return false, err
+		}
+		if _, ok := matches[key]; ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// expandElems calls found with the resolved path of every data node of config
+// matching the pattern elements below the node at resolved, whose schema is e.
+func (s *Server) expandElems(config ygot.GoStruct, resolved []*pb.PathElem, e *yang.Entry, pattern []*pb.PathElem, found func([]*pb.PathElem) error) error {
+	if len(pattern) == 0 {
+		return found(resolved)
+	}
+	elem := pattern[0]
+	children := schemaChildren(e)
+
+	switch elem.GetName() {
+	case "...":
+		// Match no element, then one more element of any name.
+		if err := s.expandElems(config, resolved, e, pattern[1:], found); err != nil {
+			return err
+		}
+		for _, ch := range children {
+			if !ch.IsDir() {
+				continue
+			}
+			for _, next := range s.childNodes(config, resolved, ch, nil) {
+				if err := s.expandElems(config, next, ch, pattern, found); err != nil {
+					return err
+				}
+			}
+		}
+	case "*":
+		for _, ch := range children {
+			for _, next := range s.childNodes(config, resolved, ch, elem.GetKey()) {
+				if err := s.expandElems(config, next, ch, pattern[1:], found); err != nil {
+					return err
+				}
+			}
+		}
+	default:
+		ch, ok := children[elem.GetName()]
+		if !ok {
+			return nil
+		}
+		for _, next := range s.childNodes(config, resolved, ch, elem.GetKey()) {
+			if err := s.expandElems(config, next, ch, pattern[1:], found); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// childNodes returns the resolved paths of the data nodes of config of schema
+// e below the node at resolved. The list keys missing from key match any
+// value.
+func (s *Server) childNodes(config ygot.GoStruct, resolved []*pb.PathElem, e *yang.Entry, key map[string]string) [][]*pb.PathElem {
+	elem := &pb.PathElem{Name: e.Name}
+	if e.IsList() {
+		elem.Key = make(map[string]string)
+		for _, k := range strings.Fields(e.Key) {
+			elem.Key[k] = "*"
+			if v, ok := key[k]; ok {
+				elem.Key[k] = v
+			}
+		}
+	}
+	elems := append(append([]*pb.PathElem{}, resolved...), elem)
+
+	nodes, err := ytypes.GetNode(s.model.schemaTreeRoot, config, &pb.Path{Elem: elems}, &ytypes.GetHandleWildcards{})
+	if err != nil {
+		// The node does not exist in the data tree.
+		return nil
+	}
+	var paths [][]*pb.PathElem
+	for _, node := range nodes {
+		if util.IsValueNil(node.Data) {
+			continue
+		}
+		paths = append(paths, node.Path.GetElem())
+	}
+	return paths
+}
@@ -0,0 +1,191 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/google/gnxi/gnmi"
+	"github.com/google/gnxi/utils/credentials"
+)
+
+// revisionJSON is the JSON representation of a config revision.
+type revisionJSON struct {
+	ID        uint64          `json:"id"`
+	Timestamp string          `json:"timestamp"`
+	User      string          `json:"user,omitempty"`
+	Diff      json.RawMessage `json:"diff,omitempty"`
+}
+
+// adminHandler serves the HTTP admin API of the config revisions:
+//
+//	GET  /revisions                 lists the revisions.
+//	GET  /revisions/diff?from=N&to=M returns the changes from revision N to M.
+//	POST /revisions/rollback?id=N   rolls the config back to revision N.
+//
+// Requests authenticate with HTTP basic auth, checked like the gNMI
+// credentials. The changes of the revisions are limited to the paths the user
+// may read under the path authorization policy of the server, if any. A rollback is not subject to the master arbitration, and fails
+// with 409 Conflict while a commit confirmed Set waits for confirmation.
+func adminHandler(s *gnmi.Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/revisions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var revs []revisionJSON
+		for _, rev := range s.Revisions(adminUserContext(r)) {
+			diff, err := protojson.Marshal(rev.Diff)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			revs = append(revs, revisionJSON{
+				ID:        rev.ID,
+				Timestamp: rev.Timestamp.Format(time.RFC3339Nano),
+				User:      rev.User,
+				Diff:      diff,
+			})
+		}
+		writeJSON(w, revs)
+	})
+	mux.HandleFunc("/revisions/diff", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		from, err := strconv.ParseUint(r.FormValue("from"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid from revision: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		to, err := strconv.ParseUint(r.FormValue("to"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid to revision: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		diff, err := s.RevisionDiff(adminUserContext(r), from, to)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeProto(w, diff)
+	})
+	mux.HandleFunc("/revisions/rollback", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid revision: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		user, _, _ := r.BasicAuth()
+		resp, err := s.Rollback(adminUserContext(r), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Infof("rolled back to revision %d by %q", id, user)
+		writeProto(w, resp)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		ctx := metadata.NewIncomingContext(r.Context(), metadata.Pairs("username", user, "password", pass))
+		msg, ok := credentials.AuthorizeUser(ctx)
+		if !ok {
+			log.Infof("denied an admin request: %v", msg)
+			http.Error(w, msg, http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// checkAdminAddress checks that addr, where the admin API is served, is a
+// loopback address. The API is plain HTTP, so its basic auth passwords would
+// otherwise cross the network in clear text.
+func checkAdminAddress(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid admin address %q: %v", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("admin address %q is not a loopback address", addr)
+	}
+	return nil
+}
+
+// adminUserContext returns the context of the admin request r, holding the
+// user of its basic auth.
+func adminUserContext(r *http.Request) context.Context {
+	user, _, _ := r.BasicAuth()
+	return gnmi.NewUserContext(r.Context(), user)
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("error in writing admin response: %v", err)
+	}
+}
+
+// writeProto writes m as the JSON response.
+func writeProto(w http.ResponseWriter, m proto.Message) {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, json.RawMessage(b))
+}
+
+// writeError writes the gRPC status error err as the HTTP error response.
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := http.StatusInternalServerError
+	switch st.Code() {
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.FailedPrecondition:
+		code = http.StatusConflict
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	}
+	http.Error(w, st.Message(), code)
+}
@@ -0,0 +1,291 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/google/gnxi/gnmi"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+	extpb "github.com/openconfig/gnmi/proto/gnmi_ext"
+)
+
+// newAdminServer returns a server with history whose startup hostname is
+// "startup", after alice set it to "a".
+func newAdminServer(t *testing.T) *gnmi.Server {
+	t.Helper()
+	config := []byte(`{"openconfig-system:system": {"config": {"hostname": "startup"}}}`)
+	s, err := gnmi.NewServer(newModel(), config, nil, gnmi.WithHistorySize(10))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	if _, err := s.Set(gnmi.NewUserContext(context.Background(), "alice"), setHostname("a")); err != nil {
+		t.Fatalf("error in setting hostname: %v", err)
+	}
+	return s
+}
+
+// setHostname returns the SetRequest setting the hostname to name.
+func setHostname(name string) *pb.SetRequest {
+	return &pb.SetRequest{Update: []*pb.Update{{
+		Path: &pb.Path{Elem: []*pb.PathElem{{Name: "system"}, {Name: "config"}, {Name: "hostname"}}},
+		Val:  &pb.TypedValue{Value: &pb.TypedValue_StringVal{StringVal: name}},
+	}}}
+}
+
+// serveAdmin serves the admin request of method and target by h, with the
+// basic auth credentials user and pass if user is not empty.
+func serveAdmin(h http.Handler, method, target, user, pass string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// hostname returns the hostname in the config of s.
+func hostname(t *testing.T, s *gnmi.Server) string {
+	t.Helper()
+	resp, err := s.Get(context.Background(), &pb.GetRequest{
+		Path: []*pb.Path{{Elem: []*pb.PathElem{{Name: "system"}, {Name: "config"}, {Name: "hostname"}}}},
+	})
+	if err != nil {
+		t.Fatalf("error in getting hostname: %v", err)
+	}
+	return resp.GetNotification()[0].GetUpdate()[0].GetVal().GetStringVal()
+}
+
+func TestAdminHandler(t *testing.T) {
+	s := newAdminServer(t)
+	h := adminHandler(s)
+
+	tests := []struct {
+		desc     string
+		method   string
+		target   string
+		wantCode int
+		wantBody string
+	}{{
+		desc:     "list revisions",
+		method:   http.MethodGet,
+		target:   "/revisions",
+		wantCode: http.StatusOK,
+		wantBody: `"id":2,`,
+	}, {
+		desc:     "list revisions with POST",
+		method:   http.MethodPost,
+		target:   "/revisions",
+		wantCode: http.StatusMethodNotAllowed,
+	}, {
+		desc:     "diff of revisions",
+		method:   http.MethodGet,
+		target:   "/revisions/diff?from=2&to=1",
+		wantCode: http.StatusOK,
+		wantBody: `"stringVal":"startup"`,
+	}, {
+		desc:     "diff with an invalid revision",
+		method:   http.MethodGet,
+		target:   "/revisions/diff?from=two&to=1",
+		wantCode: http.StatusBadRequest,
+	}, {
+		desc:     "diff with an unknown revision",
+		method:   http.MethodGet,
+		target:   "/revisions/diff?from=1&to=7",
+		wantCode: http.StatusNotFound,
+	}, {
+		desc:     "rollback with GET",
+		method:   http.MethodGet,
+		target:   "/revisions/rollback?id=1",
+		wantCode: http.StatusMethodNotAllowed,
+	}, {
+		desc:     "rollback to an unknown revision",
+		method:   http.MethodPost,
+		target:   "/revisions/rollback?id=7",
+		wantCode: http.StatusNotFound,
+	}}
+	for _, test := range tests {
+		rec := serveAdmin(h, test.method, test.target, "", "")
+		if rec.Code != test.wantCode {
+			t.Errorf("%s: got status %d, want %d: %s", test.desc, rec.Code, test.wantCode, rec.Body)
+			continue
+		}
+		if !strings.Contains(rec.Body.String(), test.wantBody) {
+			t.Errorf("%s: got body %s, want it to contain %s", test.desc, rec.Body, test.wantBody)
+		}
+	}
+
+	if rec := serveAdmin(h, http.MethodPost, "/revisions/rollback?id=1", "carol", "any"); rec.Code != http.StatusOK {
+		t.Fatalf("got status %d in rolling back, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := hostname(t, s); got != "startup" {
+		t.Errorf("got hostname %q after rollback, want %q", got, "startup")
+	}
+	revs := s.Revisions(context.Background())
+	if got := revs[len(revs)-1].User; got != "carol" {
+		t.Errorf("got user %q of the rollback revision, want %q", got, "carol")
+	}
+}
+
+func TestAdminRollbackArbitration(t *testing.T) {
+	s := newAdminServer(t)
+	req := setHostname("b")
+	req.Extension = []*extpb.Extension{{Ext: &extpb.Extension_MasterArbitration{
+		MasterArbitration: &extpb.MasterArbitration{ElectionId: &extpb.Uint128{Low: 5}},
+	}}}
+	if _, err := s.Set(context.Background(), req); err != nil {
+		t.Fatalf("error in setting hostname as primary: %v", err)
+	}
+
+	rec := serveAdmin(adminHandler(s), http.MethodPost, "/revisions/rollback?id=1", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d in rolling back with a primary, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := hostname(t, s); got != "startup" {
+		t.Errorf("got hostname %q after rollback, want %q", got, "startup")
+	}
+}
+
+func TestAdminRollbackPendingCommit(t *testing.T) {
+	s := newAdminServer(t)
+	commit := func(action *extpb.Commit) *pb.SetRequest {
+		req := &pb.SetRequest{}
+		if action.GetCommit() != nil {
+			req = setHostname("b")
+		}
+		req.Extension = []*extpb.Extension{{Ext: &extpb.Extension_Commit{Commit: action}}}
+		return req
+	}
+	if _, err := s.Set(context.Background(), commit(&extpb.Commit{
+		Id:     "c1",
+		Action: &extpb.Commit_Commit{Commit: &extpb.CommitRequest{RollbackDuration: durationpb.New(time.Minute)}},
+	})); err != nil {
+		t.Fatalf("error in committing hostname: %v", err)
+	}
+
+	h := adminHandler(s)
+	if rec := serveAdmin(h, http.MethodPost, "/revisions/rollback?id=1", "", ""); rec.Code != http.StatusConflict {
+		t.Errorf("got status %d in rolling back with a pending commit, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+
+	if _, err := s.Set(context.Background(), commit(&extpb.Commit{
+		Id:     "c1",
+		Action: &extpb.Commit_Confirm{Confirm: &extpb.CommitConfirm{}},
+	})); err != nil {
+		t.Fatalf("error in confirming commit: %v", err)
+	}
+	if rec := serveAdmin(h, http.MethodPost, "/revisions/rollback?id=1", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("got status %d in rolling back after the confirmation, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	flag.Set("username", "admin")
+	flag.Set("password", "secret")
+	defer func() {
+		flag.Set("username", "")
+		flag.Set("password", "")
+	}()
+	h := adminHandler(newAdminServer(t))
+
+	tests := []struct {
+		desc     string
+		user     string
+		pass     string
+		wantCode int
+	}{{
+		desc:     "no credentials",
+		wantCode: http.StatusUnauthorized,
+	}, {
+		desc:     "wrong password",
+		user:     "admin",
+		pass:     "guess",
+		wantCode: http.StatusUnauthorized,
+	}, {
+		desc:     "valid credentials",
+		user:     "admin",
+		pass:     "secret",
+		wantCode: http.StatusOK,
+	}}
+	for _, test := range tests {
+		if rec := serveAdmin(h, http.MethodGet, "/revisions", test.user, test.pass); rec.Code != test.wantCode {
+			t.Errorf("%s: got status %d, want %d: %s", test.desc, rec.Code, test.wantCode, rec.Body)
+		}
+	}
+}
+
+func TestCheckAdminAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{addr: "localhost:8080"},
+		{addr: "127.0.0.1:8080"},
+		{addr: "[::1]:8080"},
+		{addr: ":8080", wantErr: true},
+		{addr: "0.0.0.0:8080", wantErr: true},
+		{addr: "192.0.2.1:8080", wantErr: true},
+		{addr: "8080", wantErr: true},
+	}
+	for _, test := range tests {
+		err := checkAdminAddress(test.addr)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("checkAdminAddress(%q): got error %v, want error %v", test.addr, err, test.wantErr)
+		}
+	}
+}
+
+func TestAdminPolicy(t *testing.T) {
+	policy, err := gnmi.NewPolicy([]byte(`{
+		"rules": [
+			{"path": "/", "users": ["admin", "alice"], "access": "write"},
+			{"path": "/system/config/hostname", "users": ["alice"], "access": "deny"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("error in loading policy: %v", err)
+	}
+	s, err := gnmi.NewServer(newModel(), nil, nil, gnmi.WithHistorySize(10), gnmi.WithPathAuthorizer(policy))
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	if _, err := s.Set(gnmi.NewUserContext(context.Background(), "admin"), setHostname("secret-name")); err != nil {
+		t.Fatalf("error in setting hostname: %v", err)
+	}
+	h := adminHandler(s)
+
+	for _, target := range []string{"/revisions", "/revisions/diff?from=1&to=2"} {
+		for user, want := range map[string]bool{"admin": true, "alice": false} {
+			rec := serveAdmin(h, http.MethodGet, target, user, "any")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s by %s: got status %d, want %d: %s", target, user, rec.Code, http.StatusOK, rec.Body)
+			}
+			if got := strings.Contains(rec.Body.String(), "secret-name"); got != want {
+				t.Errorf("%s by %s: got hostname in body %v, want %v: %s", target, user, got, want, rec.Body)
+			}
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"reflect"
 
@@ -41,16 +42,24 @@ import (
 )
 
 var (
-	bindAddr   = flag.String("bind_address", ":9339", "Bind to address:port or just :port")
-	configFile = flag.String("config", "", "IETF JSON file for target startup config")
+	bindAddr       = flag.String("bind_address", ":9339", "Bind to address:port or just :port")
+	configFile     = flag.String("config", "", "IETF JSON file for target startup config")
+	historySize    = flag.Int("history_size", 0, "Number of config revisions kept for the History extension and the admin API, disabled if 0")
+	adminAddr      = flag.String("admin_address", "", "Bind the HTTP admin API of the config revisions to address:port, which must be a loopback address, disabled if empty")
+	policyFile     = flag.String("policy", "", "JSON file of the path-based authorization policy of the users authenticated with -users or -username, who have full access if empty")
+	simProfileFile = flag.String("sim_profile", "", "JSON profile of the simulated telemetry of the interface counters, component temperatures and CPU utilization, disabled if empty")
+	persistFile    = flag.String("persist_config", "", "IETF JSON file the running config is saved to after every Set, and loaded at startup instead of the startup config if valid")
+	appliedState   = flag.Bool("applied_state", false, "Reflect the committed config leaves into the sibling state containers after every Set")
+	appliedDelay   = flag.Duration("applied_state_delay", 0, "Delay of reflecting the committed config into the state containers with -applied_state")
+	queueLimit     = flag.Int("subscriber_queue_limit", 0, "Disconnect a STREAM subscriber once this many messages are queued to it, unbounded if 0")
 )
 
 type server struct {
 	*gnmi.Server
 }
 
-func newServer(model *gnmi.Model, config []byte) (*server, error) {
-	s, err := gnmi.NewServer(model, config, nil)
+func newServer(model *gnmi.Model, config []byte, opts ...gnmi.ServerOpt) (*server, error) {
+	s, err := gnmi.NewServer(model, config, nil, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +74,7 @@ func (s *server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse,
 		return nil, status.Error(codes.PermissionDenied, msg)
 	}
 	log.Infof("allowed a Get request: %v", msg)
-	return s.Server.Get(ctx, req)
+	return s.Server.Get(gnmi.NewUserContext(ctx, credentials.Username(ctx)), req)
 }
 
 // Set overrides the Set func of gnmi.Target to provide user auth.
@@ -76,7 +85,7 @@ func (s *server) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse,
 		return nil, status.Error(codes.PermissionDenied, msg)
 	}
 	log.Infof("allowed a Set request: %v", msg)
-	return s.Server.Set(ctx, req)
+	return s.Server.Set(gnmi.NewUserContext(ctx, credentials.Username(ctx)), req)
 }
 
 // Set overrides the Subscribe func of gnmi.Target to provide user auth.
@@ -87,15 +96,34 @@ func (s *server) Subscribe(stream pb.GNMI_SubscribeServer) error {
 		return status.Error(codes.PermissionDenied, msg)
 	}
 	log.Infof("allowed a Subscribe request: %v", msg)
-	return s.Server.Subscribe(stream)
+	return s.Server.Subscribe(&userStream{
+		GNMI_SubscribeServer: stream,
+		ctx:                  gnmi.NewUserContext(stream.Context(), credentials.Username(stream.Context())),
+	})
 }
 
-func main() {
-	model := gnmi.NewModel(modeldata.ModelData,
+// userStream is a Subscribe stream whose context holds the user of the
+// subscription.
+type userStream struct {
+	pb.GNMI_SubscribeServer
+	ctx context.Context
+}
+
+func (s *userStream) Context() context.Context {
+	return s.ctx
+}
+
+// newModel returns the model of the target.
+func newModel() *gnmi.Model {
+	return gnmi.NewModel(modeldata.ModelData,
 		reflect.TypeOf((*gostruct.Device)(nil)),
 		gostruct.SchemaTree["Device"],
 		gostruct.Unmarshal,
 		gostruct.ΛEnum)
+}
+
+func main() {
+	model := newModel()
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Supported models:\n")
@@ -110,6 +138,9 @@ func main() {
 	flag.Set("logtostderr", "true")
 	flag.Parse()
 
+	if err := credentials.LoadUsers(); err != nil {
+		log.Exitf("error in loading users: %v", err)
+	}
 	opts := credentials.ServerCredentials()
 	g := grpc.NewServer(opts...)
 
@@ -121,13 +152,64 @@ func main() {
 			log.Exitf("error in reading config file: %v", err)
 		}
 	}
-	s, err := newServer(model, configData)
+	var serverOpts []gnmi.ServerOpt
+	if *persistFile != "" {
+		serverOpts = append(serverOpts, gnmi.WithPersistence(*persistFile))
+	}
+	if *historySize > 0 {
+		serverOpts = append(serverOpts, gnmi.WithHistorySize(*historySize))
+	}
+	if *appliedState {
+		serverOpts = append(serverOpts, gnmi.WithAppliedState(*appliedDelay))
+	}
+	if *queueLimit > 0 {
+		serverOpts = append(serverOpts, gnmi.WithSubscriberQueueLimit(*queueLimit))
+	}
+	if *policyFile != "" {
+		if !credentials.AuthenticatesUsers() {
+			log.Exit("-policy requires the users to be authenticated with -users or -username and -password")
+		}
+		policyData, err := ioutil.ReadFile(*policyFile)
+		if err != nil {
+			log.Exitf("error in reading policy file: %v", err)
+		}
+		policy, err := gnmi.NewPolicy(policyData)
+		if err != nil {
+			log.Exitf("error in loading policy: %v", err)
+		}
+		serverOpts = append(serverOpts, gnmi.WithPathAuthorizer(policy))
+	}
+	s, err := newServer(model, configData, serverOpts...)
 	if err != nil {
 		log.Exitf("error in creating gnmi target: %v", err)
 	}
 	pb.RegisterGNMIServer(g, s)
 	reflection.Register(g)
 
+	if *simProfileFile != "" {
+		profile, err := ioutil.ReadFile(*simProfileFile)
+		if err != nil {
+			log.Exitf("error in reading simulation profile: %v", err)
+		}
+		interval, sims, err := newSimulators(profile)
+		if err != nil {
+			log.Exitf("error in loading simulation profile: %v", err)
+		}
+		go runSimulators(s.Server, interval, sims)
+	}
+
+	if *adminAddr != "" {
+		if err := checkAdminAddress(*adminAddr); err != nil {
+			log.Exit(err)
+		}
+		go func() {
+			log.Infof("starting to serve the admin API on %s", *adminAddr)
+			if err := http.ListenAndServe(*adminAddr, adminHandler(s.Server)); err != nil {
+				log.Exitf("failed to serve the admin API: %v", err)
+			}
+		}()
+	}
+
 	log.Infof("starting to listen on %s", *bindAddr)
 	listen, err := net.Listen("tcp", *bindAddr)
 	if err != nil {
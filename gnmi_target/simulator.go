@@ -0,0 +1,293 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	log "github.com/golang/glog"
+
+	"github.com/google/gnxi/gnmi"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+// defaultSimInterval is the interval between the steps of the simulation when
+// the profile does not set one.
+const defaultSimInterval = time.Second
+
+// simProfile is the profile of the telemetry simulation, read from a JSON file
+// such as:
+//
+//	{
+//	  "interval": "1s",
+//	  "interfaces": [
+//	    {"name": "eth0", "in_octets_rate": 125000, "out_octets_rate": 62500, "in_pkts_rate": 100, "out_pkts_rate": 50}
+//	  ],
+//	  "components": [
+//	    {"name": "chassis", "temperature": {"min": 35, "max": 55}}
+//	  ],
+//	  "cpus": [
+//	    {"index": 0, "utilization": {"min": 5, "max": 60}}
+//	  ]
+//	}
+//
+// The rates are per second.
+type simProfile struct {
+	Interval   string                `json:"interval"`
+	Interfaces []interfaceSimProfile `json:"interfaces"`
+	Components []componentSimProfile `json:"components"`
+	CPUs       []cpuSimProfile       `json:"cpus"`
+}
+
+type interfaceSimProfile struct {
+	Name          string  `json:"name"`
+	InOctetsRate  float64 `json:"in_octets_rate"`
+	OutOctetsRate float64 `json:"out_octets_rate"`
+	InPktsRate    float64 `json:"in_pkts_rate"`
+	OutPktsRate   float64 `json:"out_pkts_rate"`
+	InErrorsRate  float64 `json:"in_errors_rate"`
+	OutErrorsRate float64 `json:"out_errors_rate"`
+}
+
+type componentSimProfile struct {
+	Name        string   `json:"name"`
+	Temperature simRange `json:"temperature"`
+}
+
+type cpuSimProfile struct {
+	Index       uint32   `json:"index"`
+	Utilization simRange `json:"utilization"`
+}
+
+// simRange bounds a value varying randomly.
+type simRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// stateSimulator advances a part of the operational state of the device.
+type stateSimulator interface {
+	// step advances the state by elapsed time, and returns the updates of the
+	// state leaves.
+	step(elapsed time.Duration) []*pb.Update
+}
+
+// newSimulators returns the interval between the steps of the simulation and
+// the simulators of the profile in the JSON data.
+func newSimulators(data []byte) (time.Duration, []stateSimulator, error) {
+	var p simProfile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return 0, nil, fmt.Errorf("error in parsing simulation profile: %v", err)
+	}
+	interval := defaultSimInterval
+	if p.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(p.Interval); err != nil || interval <= 0 {
+			return 0, nil, fmt.Errorf("invalid simulation interval %q", p.Interval)
+		}
+	}
+
+	var sims []stateSimulator
+	for _, intf := range p.Interfaces {
+		if intf.Name == "" {
+			return 0, nil, fmt.Errorf("interface name is required")
+		}
+		for _, rate := range []float64{intf.InOctetsRate, intf.OutOctetsRate, intf.InPktsRate, intf.OutPktsRate, intf.InErrorsRate, intf.OutErrorsRate} {
+			if rate < 0 {
+				return 0, nil, fmt.Errorf("interface %q: counter rates must not be negative", intf.Name)
+			}
+		}
+		sims = append(sims, newInterfaceSimulator(intf))
+	}
+	for _, c := range p.Components {
+		if c.Name == "" {
+			return 0, nil, fmt.Errorf("component name is required")
+		}
+		if c.Temperature.Min > c.Temperature.Max {
+			return 0, nil, fmt.Errorf("component %q: temperature min is above max", c.Name)
+		}
+		sims = append(sims, &temperatureSimulator{name: c.Name, gauge: newGauge(c.Temperature)})
+	}
+	for _, c := range p.CPUs {
+		if c.Utilization.Min < 0 || c.Utilization.Max > 100 || c.Utilization.Min > c.Utilization.Max {
+			return 0, nil, fmt.Errorf("cpu %d: utilization must be a range within 0 and 100", c.Index)
+		}
+		sims = append(sims, &cpuSimulator{index: c.Index, gauge: newGauge(c.Utilization)})
+	}
+	return interval, sims, nil
+}
+
+// runSimulators steps the simulators every interval. It never returns.
+func runSimulators(s *gnmi.Server, interval time.Duration, sims []stateSimulator) {
+	last := time.Now()
+	for now := range time.Tick(interval) {
+		if err := simulate(s, sims, now.Sub(last)); err != nil {
+			log.Errorf("error in simulating telemetry: %v", err)
+		}
+		last = now
+	}
+}
+
+// simulate steps the simulators by elapsed time, and writes the state they
+// produce to the state tree of s through UpdateState. The config of s is left
+// untouched.
+func simulate(s *gnmi.Server, sims []stateSimulator, elapsed time.Duration) error {
+	n := &pb.Notification{Timestamp: time.Now().UnixNano()}
+	for _, sim := range sims {
+		n.Update = append(n.Update, sim.step(elapsed)...)
+	}
+	return s.UpdateState(n)
+}
+
+// leafUpdate returns the update of the leaf at the path of the elem names
+// below the list entry at the path of entry.
+func leafUpdate(entry []*pb.PathElem, val *pb.TypedValue, names ...string) *pb.Update {
+	elems := append([]*pb.PathElem{}, entry...)
+	for _, name := range names {
+		elems = append(elems, &pb.PathElem{Name: name})
+	}
+	return &pb.Update{Path: &pb.Path{Elem: elems}, Val: val}
+}
+
+// interfaceCounters are the names of the simulated counters of an interface.
+var interfaceCounters = []string{"in-octets", "out-octets", "in-pkts", "out-pkts", "in-errors", "out-errors"}
+
+// interfaceSimulator advances the counters of an interface at the rates of its
+// profile.
+type interfaceSimulator struct {
+	name  string
+	rates map[string]float64 // rates per second, by counter name.
+	// counters holds the values of the counters, and carry the fractions not
+	// counted yet, by counter name.
+	counters map[string]uint64
+	carry    map[string]float64
+}
+
+func newInterfaceSimulator(p interfaceSimProfile) *interfaceSimulator {
+	return &interfaceSimulator{
+		name: p.Name,
+		rates: map[string]float64{
+			"in-octets":  p.InOctetsRate,
+			"out-octets": p.OutOctetsRate,
+			"in-pkts":    p.InPktsRate,
+			"out-pkts":   p.OutPktsRate,
+			"in-errors":  p.InErrorsRate,
+			"out-errors": p.OutErrorsRate,
+		},
+		counters: make(map[string]uint64),
+		carry:    make(map[string]float64),
+	}
+}
+
+func (sim *interfaceSimulator) step(elapsed time.Duration) []*pb.Update {
+	entry := []*pb.PathElem{{Name: "interfaces"}, {Name: "interface", Key: map[string]string{"name": sim.name}}}
+	var updates []*pb.Update
+	for _, name := range interfaceCounters {
+		v := sim.advance(name, sim.rates[name]*elapsed.Seconds())
+		updates = append(updates, leafUpdate(entry, &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: v}}, "state", "counters", name))
+	}
+	return updates
+}
+
+// advance increases the named counter by delta, carrying the fraction of
+// delta over to the next step, and returns its value.
+func (sim *interfaceSimulator) advance(name string, delta float64) uint64 {
+	total := sim.carry[name] + delta
+	whole := math.Floor(total)
+	sim.carry[name] = total - whole
+	sim.counters[name] += uint64(whole)
+	return sim.counters[name]
+}
+
+// gauge is a value varying randomly within a range.
+type gauge struct {
+	r   simRange
+	val float64
+	// min, max and sum of the values, and their count.
+	min, max, sum float64
+	n             int
+}
+
+func newGauge(r simRange) *gauge {
+	return &gauge{r: r, val: r.Min + rand.Float64()*(r.Max-r.Min)}
+}
+
+// next moves the value randomly by up to a tenth of the range, within the
+// range, and returns it.
+func (g *gauge) next() float64 {
+	g.val += (rand.Float64()*2 - 1) * (g.r.Max - g.r.Min) / 10
+	g.val = math.Max(g.r.Min, math.Min(g.r.Max, g.val))
+	if g.n == 0 || g.val < g.min {
+		g.min = g.val
+	}
+	if g.n == 0 || g.val > g.max {
+		g.max = g.val
+	}
+	g.sum += g.val
+	g.n++
+	return g.val
+}
+
+// avg returns the average of the values.
+func (g *gauge) avg() float64 {
+	return g.sum / float64(g.n)
+}
+
+// temperatureSimulator varies the temperature of a component.
+type temperatureSimulator struct {
+	name  string
+	gauge *gauge
+}
+
+func (sim *temperatureSimulator) step(time.Duration) []*pb.Update {
+	entry := []*pb.PathElem{{Name: "components"}, {Name: "component", Key: map[string]string{"name": sim.name}}}
+	// The temperatures are decimals with 1 fraction digit.
+	val := func(v float64) *pb.TypedValue {
+		return &pb.TypedValue{Value: &pb.TypedValue_DecimalVal{DecimalVal: &pb.Decimal64{Digits: int64(math.Round(v * 10)), Precision: 1}}}
+	}
+	instant := sim.gauge.next()
+	return []*pb.Update{
+		leafUpdate(entry, val(instant), "state", "temperature", "instant"),
+		leafUpdate(entry, val(sim.gauge.min), "state", "temperature", "min"),
+		leafUpdate(entry, val(sim.gauge.max), "state", "temperature", "max"),
+		leafUpdate(entry, val(sim.gauge.avg()), "state", "temperature", "avg"),
+	}
+}
+
+// cpuSimulator varies the total utilization of a CPU, in percent.
+type cpuSimulator struct {
+	index uint32
+	gauge *gauge
+}
+
+func (sim *cpuSimulator) step(time.Duration) []*pb.Update {
+	entry := []*pb.PathElem{{Name: "system"}, {Name: "cpus"}, {Name: "cpu", Key: map[string]string{"index": fmt.Sprint(sim.index)}}}
+	val := func(v float64) *pb.TypedValue {
+		return &pb.TypedValue{Value: &pb.TypedValue_UintVal{UintVal: uint64(math.Round(v))}}
+	}
+	instant := sim.gauge.next()
+	return []*pb.Update{
+		leafUpdate(entry, val(instant), "state", "total", "instant"),
+		leafUpdate(entry, val(sim.gauge.min), "state", "total", "min"),
+		leafUpdate(entry, val(sim.gauge.max), "state", "total", "max"),
+		leafUpdate(entry, val(sim.gauge.avg()), "state", "total", "avg"),
+	}
+}
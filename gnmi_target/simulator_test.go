@@ -0,0 +1,188 @@
+/* Copyright 2017 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/openconfig/ygot/ygot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/google/gnxi/gnmi"
+
+	pb "github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestNewSimulators(t *testing.T) {
+	tests := []struct {
+		desc         string
+		profile      string
+		wantInterval time.Duration
+		wantSims     int
+		wantErr      bool
+	}{{
+		desc:         "default interval",
+		profile:      `{"interfaces": [{"name": "eth0", "in_octets_rate": 100}]}`,
+		wantInterval: defaultSimInterval,
+		wantSims:     1,
+	}, {
+		desc: "all simulators",
+		profile: `{
+			"interval": "500ms",
+			"interfaces": [{"name": "eth0"}, {"name": "eth1"}],
+			"components": [{"name": "chassis", "temperature": {"min": 35, "max": 55}}],
+			"cpus": [{"index": 0, "utilization": {"min": 5, "max": 60}}]
+		}`,
+		wantInterval: 500 * time.Millisecond,
+		wantSims:     4,
+	}, {
+		desc:    "invalid JSON",
+		profile: `{"interfaces": [`,
+		wantErr: true,
+	}, {
+		desc:    "invalid interval",
+		profile: `{"interval": "soon"}`,
+		wantErr: true,
+	}, {
+		desc:    "negative interval",
+		profile: `{"interval": "-1s"}`,
+		wantErr: true,
+	}, {
+		desc:    "interface without name",
+		profile: `{"interfaces": [{"in_octets_rate": 100}]}`,
+		wantErr: true,
+	}, {
+		desc:    "negative counter rate",
+		profile: `{"interfaces": [{"name": "eth0", "out_pkts_rate": -1}]}`,
+		wantErr: true,
+	}, {
+		desc:    "temperature min above max",
+		profile: `{"components": [{"name": "chassis", "temperature": {"min": 55, "max": 35}}]}`,
+		wantErr: true,
+	}, {
+		desc:    "utilization above 100",
+		profile: `{"cpus": [{"index": 0, "utilization": {"min": 5, "max": 160}}]}`,
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			interval, sims, err := newSimulators([]byte(test.profile))
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if interval != test.wantInterval {
+				t.Errorf("got interval %v, want %v", interval, test.wantInterval)
+			}
+			if len(sims) != test.wantSims {
+				t.Errorf("got %d simulators, want %d", len(sims), test.wantSims)
+			}
+		})
+	}
+}
+
+func TestInterfaceSimulatorCarry(t *testing.T) {
+	sim := newInterfaceSimulator(interfaceSimProfile{Name: "eth0", InOctetsRate: 1.5, OutOctetsRate: 1000})
+	// The fractions of the counters are carried over to the next steps.
+	for i, want := range []uint64{0, 1, 2, 3, 3, 4, 5} {
+		counters := make(map[string]uint64)
+		for _, u := range sim.step(500 * time.Millisecond) {
+			elems := u.GetPath().GetElem()
+			counters[elems[len(elems)-1].GetName()] = u.GetVal().GetUintVal()
+		}
+		if got := counters["in-octets"]; got != want {
+			t.Errorf("step %d: got in-octets %d, want %d", i, got, want)
+		}
+		if got, want := counters["out-octets"], uint64(500*(i+1)); got != want {
+			t.Errorf("step %d: got out-octets %d, want %d", i, got, want)
+		}
+		if got := counters["in-pkts"]; got != 0 {
+			t.Errorf("step %d: got in-pkts %d, want 0", i, got)
+		}
+	}
+}
+
+func TestGaugeBounds(t *testing.T) {
+	r := simRange{Min: 35, Max: 55}
+	g := newGauge(r)
+	for i := 0; i < 1000; i++ {
+		if v := g.next(); v < r.Min || v > r.Max {
+			t.Fatalf("step %d: got value %v out of range %v", i, v, r)
+		}
+		if avg := g.avg(); g.min < r.Min || g.max > r.Max || avg < g.min || avg > g.max {
+			t.Fatalf("step %d: got min %v, avg %v and max %v, want them ordered within %v", i, g.min, avg, g.max, r)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	s, err := gnmi.NewServer(newModel(), nil, nil)
+	if err != nil {
+		t.Fatalf("error in creating server: %v", err)
+	}
+	_, sims, err := newSimulators([]byte(`{
+		"interfaces": [{"name": "eth0", "in_octets_rate": 100}],
+		"components": [{"name": "chassis", "temperature": {"min": 35, "max": 55}}],
+		"cpus": [{"index": 0, "utilization": {"min": 5, "max": 60}}]
+	}`))
+	if err != nil {
+		t.Fatalf("error in loading simulation profile: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := simulate(s, sims, time.Second); err != nil {
+			t.Fatalf("error in simulating telemetry: %v", err)
+		}
+	}
+
+	get := func(path string) []*pb.Update {
+		t.Helper()
+		p, err := ygot.StringToStructuredPath(path)
+		if err != nil {
+			t.Fatalf("error in parsing path %s: %v", path, err)
+		}
+		resp, err := s.Get(nil, &pb.GetRequest{Path: []*pb.Path{p}, Encoding: pb.Encoding_PROTO})
+		if err != nil {
+			t.Fatalf("error in getting %s: %v", path, err)
+		}
+		return resp.GetNotification()[0].GetUpdate()
+	}
+	if got := get("/interfaces/interface[name=eth0]/state/counters/in-octets")[0].GetVal().GetUintVal(); got != 200 {
+		t.Errorf("got in-octets %d, want 200", got)
+	}
+	temperature := get("/components/component[name=chassis]/state/temperature/instant")[0].GetVal().GetDecimalVal()
+	if got := float64(temperature.GetDigits()) / math.Pow10(int(temperature.GetPrecision())); got < 35 || got > 55 {
+		t.Errorf("got temperature %v, want it within 35 and 55", got)
+	}
+	if got := get("/system/cpus/cpu[index=0]/state/total/instant")[0].GetVal().GetUintVal(); got < 5 || got > 60 {
+		t.Errorf("got CPU utilization %d, want it within 5 and 60", got)
+	}
+	// The simulated state is kept apart from the config.
+	for _, path := range []string{"/interfaces/interface[name=eth0]/config", "/components/component[name=chassis]/config"} {
+		p, err := ygot.StringToStructuredPath(path)
+		if err != nil {
+			t.Fatalf("error in parsing path %s: %v", path, err)
+		}
+		if _, err := s.Get(nil, &pb.GetRequest{Path: []*pb.Path{p}}); status.Code(err) != codes.NotFound {
+			t.Errorf("got error %v getting %s after simulating telemetry, want %v", err, path, codes.NotFound)
+		}
+	}
+}
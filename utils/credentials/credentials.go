@@ -19,6 +19,7 @@ package credentials
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
@@ -43,7 +44,9 @@ var (
 	TargetName     = flag.String("target_name", "", "The target name used to verify the hostname returned by TLS handshake")
 	insecure       = flag.Bool("insecure", false, "Skip TLS validation.")
 	notls          = flag.Bool("notls", false, "Disable TLS validation. If true, no need to specify TLS related options.")
+	usersFile      = flag.String("users", "", "JSON file mapping the usernames to the passwords accepted by the server, in addition to -username and -password.")
 	authorizedUser = userCredentials{}
+	users          map[string]string
 	usernameKey    = "username"
 	passwordKey    = "password"
 	caEnt          *entity.Entity
@@ -214,12 +217,59 @@ func ServerCredentials() []grpc.ServerOption {
 	}))}
 }
 
-// AuthorizeUser checks for valid credentials in the context Metadata.
-func AuthorizeUser(ctx context.Context) (string, bool) {
-	authorize := false
-	if authorizedUser.username == "" {
-		authorize = true
+// Username returns the username in the context Metadata, or an empty string
+// if there is none.
+func Username(ctx context.Context) string {
+	headers, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(headers[usernameKey]) == 0 {
+		return ""
+	}
+	return headers[usernameKey][0]
+}
+
+// LoadUsers loads the usernames and passwords accepted by the server from the
+// -users file, a JSON object such as {"alice": "secret", "bob": "hunter2"}.
+func LoadUsers() error {
+	if *usersFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*usersFile)
+	if err != nil {
+		return fmt.Errorf("could not read users file: %v", err)
 	}
+	var u map[string]string
+	if err := json.Unmarshal(data, &u); err != nil {
+		return fmt.Errorf("could not parse users file: %v", err)
+	}
+	for user, pass := range u {
+		if user == "" || pass == "" {
+			return fmt.Errorf("empty username or password in users file")
+		}
+	}
+	users = u
+	return nil
+}
+
+// AuthenticatesUsers returns true if AuthorizeUser checks the usernames
+// against their passwords, so that Username identifies the user of a request.
+func AuthenticatesUsers() bool {
+	return authorizedUser.username != "" || len(users) > 0
+}
+
+// validPassword returns true if pass is the password of user in -username and
+// -password or in the -users file.
+func validPassword(user, pass string) bool {
+	if authorizedUser.username != "" && user == authorizedUser.username && pass == authorizedUser.password {
+		return true
+	}
+	want, ok := users[user]
+	return ok && pass == want
+}
+
+// AuthorizeUser checks for valid credentials in the context Metadata. Any
+// credentials are valid if neither -username nor -users is set.
+func AuthorizeUser(ctx context.Context) (string, bool) {
+	authorize := !AuthenticatesUsers()
 	headers, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return "no Metadata found", authorize
@@ -232,7 +282,7 @@ func AuthorizeUser(ctx context.Context) (string, bool) {
 	if !ok || len(pass) == 0 {
 		return fmt.Sprintf("found username \"%s\" but no password in Metadata", user[0]), authorize
 	}
-	if authorize || pass[0] == authorizedUser.password && user[0] == authorizedUser.username {
+	if authorize || validPassword(user[0], pass[0]) {
 		return fmt.Sprintf("authorized with \"%s:%s\"", user[0], pass[0]), true
 	}
 	return fmt.Sprintf("not authorized with \"%s:%s\"", user[0], pass[0]), false
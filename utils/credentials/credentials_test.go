@@ -1,6 +1,8 @@
 package credentials
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -24,3 +26,142 @@ func TestTwiceAttachToContext(t *testing.T) {
 		t.Fatalf("(-got, +want):\n%s", diff)
 	}
 }
+
+func TestUsername(t *testing.T) {
+	tests := []struct {
+		desc string
+		ctx  context.Context
+		want string
+	}{{
+		desc: "no metadata",
+		ctx:  context.Background(),
+	}, {
+		desc: "no username",
+		ctx:  metadata.NewIncomingContext(context.Background(), metadata.Pairs("password", "bar")),
+	}, {
+		desc: "username",
+		ctx:  metadata.NewIncomingContext(context.Background(), metadata.Pairs("username", "foo", "password", "bar")),
+		want: "foo",
+	}}
+	for _, test := range tests {
+		if got := Username(test.ctx); got != test.want {
+			t.Errorf("%s: got username %q, want %q", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	defer func() {
+		authorizedUser = userCredentials{}
+		users = nil
+	}()
+	incoming := func(user, pass string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("username", user, "password", pass))
+	}
+	tests := []struct {
+		desc     string
+		username string
+		password string
+		users    map[string]string
+		ctx      context.Context
+		wantAuth bool
+		want     bool
+	}{{
+		desc: "no credentials required",
+		ctx:  incoming("anyone", "anything"),
+		want: true,
+	}, {
+		desc:     "username and password",
+		username: "foo",
+		password: "bar",
+		ctx:      incoming("foo", "bar"),
+		wantAuth: true,
+		want:     true,
+	}, {
+		desc:     "wrong password",
+		username: "foo",
+		password: "bar",
+		ctx:      incoming("foo", "baz"),
+		wantAuth: true,
+	}, {
+		desc:     "no metadata",
+		username: "foo",
+		password: "bar",
+		ctx:      context.Background(),
+		wantAuth: true,
+	}, {
+		desc:     "user of the users file",
+		users:    map[string]string{"alice": "secret", "bob": "hunter2"},
+		ctx:      incoming("bob", "hunter2"),
+		wantAuth: true,
+		want:     true,
+	}, {
+		desc:     "password of another user of the users file",
+		users:    map[string]string{"alice": "secret", "bob": "hunter2"},
+		ctx:      incoming("alice", "hunter2"),
+		wantAuth: true,
+	}, {
+		desc:     "empty credentials with a users file",
+		users:    map[string]string{"alice": "secret"},
+		ctx:      incoming("", ""),
+		wantAuth: true,
+	}, {
+		desc:     "username along with a users file",
+		username: "foo",
+		password: "bar",
+		users:    map[string]string{"alice": "secret"},
+		ctx:      incoming("foo", "bar"),
+		wantAuth: true,
+		want:     true,
+	}}
+	for _, test := range tests {
+		authorizedUser = userCredentials{username: test.username, password: test.password}
+		users = test.users
+		if got := AuthenticatesUsers(); got != test.wantAuth {
+			t.Errorf("%s: AuthenticatesUsers() = %v, want %v", test.desc, got, test.wantAuth)
+		}
+		if msg, got := AuthorizeUser(test.ctx); got != test.want {
+			t.Errorf("%s: AuthorizeUser() = %v (%s), want %v", test.desc, got, msg, test.want)
+		}
+	}
+}
+
+func TestLoadUsers(t *testing.T) {
+	defer func() {
+		*usersFile = ""
+		users = nil
+	}()
+	tests := []struct {
+		desc    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{{
+		desc: "users",
+		data: `{"alice": "secret", "bob": "hunter2"}`,
+		want: map[string]string{"alice": "secret", "bob": "hunter2"},
+	}, {
+		desc:    "invalid JSON",
+		data:    `{"alice": `,
+		wantErr: true,
+	}, {
+		desc:    "empty password",
+		data:    `{"alice": ""}`,
+		wantErr: true,
+	}}
+	for _, test := range tests {
+		users = nil
+		*usersFile = filepath.Join(t.TempDir(), "users.json")
+		if err := ioutil.WriteFile(*usersFile, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		err := LoadUsers()
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.desc, err, test.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(users, test.want); diff != "" {
+			t.Errorf("%s: (-got, +want):\n%s", test.desc, diff)
+		}
+	}
+}